@@ -0,0 +1,54 @@
+package api
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// BatchProvider is an optional interface for providers that can fetch many values at once, e.g. concurrently.
+// Values that could be fetched are returned even when fetching others failed,
+// in which case the error is a BatchError.
+type BatchProvider interface {
+	GetStrings(paths []string) (map[string]string, error)
+}
+
+// BatchError holds the errors that occurred while fetching values in a batch, keyed by path
+type BatchError map[string]error
+
+func (e BatchError) Error() string {
+	paths := make([]string, 0, len(e))
+	for path := range e {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	msgs := make([]string, 0, len(paths))
+	for _, path := range paths {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", path, e[path]))
+	}
+	return fmt.Sprintf("failed to get %d value(s): %s", len(e), strings.Join(msgs, "; "))
+}
+
+// GetStrings fetches the values for all the paths, using the provider's GetStrings if it is a BatchProvider
+// and otherwise falling back to calling GetString for each path in turn.
+func GetStrings(p LazyLoadedStringProvider, paths []string) (map[string]string, error) {
+	if bp, ok := p.(BatchProvider); ok {
+		return bp.GetStrings(paths)
+	}
+
+	res := make(map[string]string, len(paths))
+	errs := BatchError{}
+	for _, path := range paths {
+		v, err := p.GetString(path)
+		if err != nil {
+			errs[path] = err
+			continue
+		}
+		res[path] = v
+	}
+	if len(errs) > 0 {
+		return res, errs
+	}
+	return res, nil
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type stringProvider map[string]string
+
+func (p stringProvider) GetString(path string) (string, error) {
+	v, ok := p[path]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return v, nil
+}
+
+type batchProvider struct {
+	stringProvider
+	calls int
+}
+
+func (p *batchProvider) GetStrings(paths []string) (map[string]string, error) {
+	p.calls++
+	return GetStrings(p.stringProvider, paths)
+}
+
+func TestGetStrings(t *testing.T) {
+	p := stringProvider{"foo": "FOO", "bar": "BAR"}
+
+	got, err := GetStrings(p, []string{"foo", "bar"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"foo": "FOO", "bar": "BAR"}, got)
+
+	got, err = GetStrings(p, []string{"foo", "missing1", "missing2"})
+	require.EqualError(t, err, "failed to get 2 value(s): missing1: not found; missing2: not found")
+	var batchErr BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr, 2)
+	require.Equal(t, map[string]string{"foo": "FOO"}, got)
+}
+
+func TestGetStrings_BatchProvider(t *testing.T) {
+	p := &batchProvider{stringProvider: stringProvider{"foo": "FOO"}}
+
+	got, err := GetStrings(p, []string{"foo"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"foo": "FOO"}, got)
+	require.Equal(t, 1, p.calls)
+}
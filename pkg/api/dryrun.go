@@ -0,0 +1,15 @@
+package api
+
+// DryRunner is an optional interface for providers that can return placeholders instead of the values,
+// for lint or plan stages without access to the backend.
+type DryRunner interface {
+	// DryRun returns whether the provider returns placeholders without knowing the keys within the values,
+	// in which case a field within a value resolves to the placeholder that GetString returns for the whole value.
+	DryRun() bool
+}
+
+// DryRun returns whether the provider implements DryRunner and is in a dry run
+func DryRun(p interface{}) bool {
+	dr, ok := p.(DryRunner)
+	return ok && dr.DryRun()
+}
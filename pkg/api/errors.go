@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+)
+
+// Kinds of errors returned by providers, so that callers can tell them apart with errors.Is
+var (
+	// ErrNotFound means that the secret, object or key does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrPermission means that the caller is not authenticated or not allowed to read the value
+	ErrPermission = errors.New("permission denied")
+	// ErrTransient means that the error, like a timeout or a dropped connection, may go away on retry
+	ErrTransient = errors.New("transient error")
+)
+
+// kindError marks an error as one of ErrNotFound, ErrPermission or ErrTransient without changing its message
+type kindError struct {
+	err  error
+	kind error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// WithKind returns an error with the same message as err, for which errors.Is reports true for both err and kind.
+// A nil err results in nil.
+func WithKind(err error, kind error) error {
+	if err == nil {
+		return nil
+	}
+	return &kindError{err: err, kind: kind}
+}
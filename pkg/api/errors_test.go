@@ -0,0 +1,22 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKind(t *testing.T) {
+	cause := errors.New("secret mysecret does not exist")
+
+	err := fmt.Errorf("failed to get secret: %w", WithKind(cause, ErrNotFound))
+	require.EqualError(t, err, "failed to get secret: secret mysecret does not exist")
+	require.ErrorIs(t, err, ErrNotFound)
+	require.ErrorIs(t, err, cause)
+	require.NotErrorIs(t, err, ErrPermission)
+	require.NotErrorIs(t, err, ErrTransient)
+
+	require.NoError(t, WithKind(nil, ErrNotFound))
+}
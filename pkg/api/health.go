@@ -0,0 +1,21 @@
+package api
+
+import (
+	"context"
+)
+
+// HealthChecker is an optional interface for providers that can verify their connectivity and credentials
+// with a cheap request to the backend, so that a misconfiguration is caught before reading any value.
+// As no reference has been read yet, a provider checks only what its own params tell it, like the project of gcpsecrets.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthCheck checks the health of the provider if it implements HealthChecker.
+// Providers that do not implement it are assumed to be healthy.
+func HealthCheck(ctx context.Context, p interface{}) error {
+	if hc, ok := p.(HealthChecker); ok {
+		return hc.HealthCheck(ctx)
+	}
+	return nil
+}
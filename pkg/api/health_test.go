@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type healthChecker struct {
+	stringProvider
+	err error
+}
+
+func (p healthChecker) HealthCheck(ctx context.Context) error {
+	return p.err
+}
+
+func TestHealthCheck(t *testing.T) {
+	require.NoError(t, HealthCheck(context.Background(), stringProvider{}))
+	require.NoError(t, HealthCheck(context.Background(), healthChecker{}))
+	require.EqualError(t, HealthCheck(context.Background(), healthChecker{err: errors.New("unreachable")}), "unreachable")
+}
@@ -0,0 +1,7 @@
+package api
+
+// ListProvider is an optional interface for providers that can enumerate the values under a prefix,
+// e.g. to build a map of a whole family of secrets without listing their names by hand.
+type ListProvider interface {
+	List(prefix string) ([]string, error)
+}
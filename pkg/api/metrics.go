@@ -0,0 +1,27 @@
+package api
+
+import (
+	"time"
+)
+
+// MetricsReporter is an optional hook for observing the calls that providers make to their backends,
+// e.g. to export per-provider latency histograms and error rates.
+type MetricsReporter interface {
+	// ObserveLookup is called after each lookup with the name of the provider, the path looked up,
+	// how long it took including retries, and the error if it failed.
+	ObserveLookup(provider, path string, dur time.Duration, err error)
+}
+
+// NopMetricsReporter is the MetricsReporter that discards all the observations
+type NopMetricsReporter struct{}
+
+func (NopMetricsReporter) ObserveLookup(provider, path string, dur time.Duration, err error) {}
+
+// ObserveLookup reports the lookup started at start to m.
+// A nil m is the same as NopMetricsReporter.
+func ObserveLookup(m MetricsReporter, provider, path string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.ObserveLookup(provider, path, time.Since(start), err)
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type lookup struct {
+	provider, path string
+	err            error
+}
+
+type metricsReporter struct {
+	lookups []lookup
+}
+
+func (m *metricsReporter) ObserveLookup(provider, path string, dur time.Duration, err error) {
+	m.lookups = append(m.lookups, lookup{provider: provider, path: path, err: err})
+}
+
+func TestObserveLookup(t *testing.T) {
+	// Neither a nil reporter nor the no-op one panics
+	ObserveLookup(nil, "k8s", "v1/Secret/ns/name", time.Now(), nil)
+	ObserveLookup(NopMetricsReporter{}, "k8s", "v1/Secret/ns/name", time.Now(), nil)
+
+	m := &metricsReporter{}
+	err := errors.New("unreachable")
+	ObserveLookup(m, "k8s", "v1/Secret/ns/name", time.Now(), nil)
+	ObserveLookup(m, "gcpsecrets", "projects/p/secrets/s/versions/latest", time.Now(), err)
+	require.Equal(t, []lookup{
+		{provider: "k8s", path: "v1/Secret/ns/name"},
+		{provider: "gcpsecrets", path: "projects/p/secrets/s/versions/latest", err: err},
+	}, m.lookups)
+}
@@ -0,0 +1,15 @@
+package api
+
+import (
+	"time"
+)
+
+// Store is an optional cache shared across processes, like Redis or a local file,
+// which providers consult before fetching a value from the backend.
+// Values are stored as-is, so a store holding secrets must be protected like the secrets themselves.
+type Store interface {
+	// Get returns the value stored under the key, and whether it was found
+	Get(key string) ([]byte, bool)
+	// Set stores the value under the key for ttl. A zero ttl stores it until the store evicts it.
+	Set(key string, val []byte, ttl time.Duration)
+}
@@ -0,0 +1,269 @@
+package cachedprovider
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+
+	"github.com/helmfile/vals/pkg/api"
+)
+
+// EnvDisableCache is the environment variable to opt out of caching, for users who prefer each lookup to hit the backend.
+// It disables both the cache in front of providers and the caches of vals.Runtime.
+const EnvDisableCache = "VALS_DISABLE_CACHE"
+
+// Disabled reports whether caching is disabled via the VALS_DISABLE_CACHE environment variable
+func Disabled() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv(EnvDisableCache))
+	return disabled
+}
+
+// Cache memoizes the values returned by providers for the lifetime of a single evaluation.
+// Values are keyed by the provider ID, which is expected to identify the provider type and its params,
+// and the path passed to the provider.
+// Values cached with a TTL are fetched from the backend again on the first access after the TTL has elapsed.
+// Errors are never cached.
+type Cache struct {
+	strs *lru.Cache
+	maps *lru.Cache
+	// now is replaced in tests to advance the clock
+	now func() time.Time
+}
+
+type stringEntry struct {
+	value   string
+	expires time.Time
+}
+
+type stringMapEntry struct {
+	value   map[string]interface{}
+	expires time.Time
+}
+
+// NewCache returns a cache without a bound on the number of values
+func NewCache() *Cache {
+	return NewCacheWithSize(0)
+}
+
+// NewCacheWithSize returns a cache holding at most size strings and size maps, evicting the least recently used ones.
+// A zero size does not bound the cache.
+func NewCacheWithSize(size int) *Cache {
+	if size <= 0 {
+		size = math.MaxInt32
+	}
+	// lru.New fails only on a non-positive size
+	strs, _ := lru.New(size)
+	maps, _ := lru.New(size)
+	return &Cache{
+		strs: strs,
+		maps: maps,
+		now:  time.Now,
+	}
+}
+
+// expiry returns the time after which a value cached now with the TTL must be fetched again,
+// or the zero time for values cached for the lifetime of the cache
+func (c *Cache) expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+func (c *Cache) expired(expires time.Time) bool {
+	return !expires.IsZero() && !c.now().Before(expires)
+}
+
+func (c *Cache) lookupString(key string) (string, bool) {
+	v, ok := c.strs.Get(key)
+	if !ok {
+		return "", false
+	}
+	e := v.(stringEntry)
+	if c.expired(e.expires) {
+		c.strs.Remove(key)
+		return "", false
+	}
+	return e.value, true
+}
+
+func (c *Cache) addString(key string, ttl time.Duration, v string) {
+	c.strs.Add(key, stringEntry{value: v, expires: c.expiry(ttl)})
+}
+
+func (c *Cache) getString(key string, ttl time.Duration, get func() (string, error)) (string, error) {
+	if v, ok := c.lookupString(key); ok {
+		return v, nil
+	}
+
+	v, err := get()
+	if err != nil {
+		return "", err
+	}
+
+	c.addString(key, ttl, v)
+
+	return v, nil
+}
+
+func (c *Cache) getStringMap(key string, ttl time.Duration, get func() (map[string]interface{}, error)) (map[string]interface{}, error) {
+	if v, ok := c.maps.Get(key); ok {
+		e := v.(stringMapEntry)
+		if !c.expired(e.expires) {
+			return e.value, nil
+		}
+		c.maps.Remove(key)
+	}
+
+	v, err := get()
+	if err != nil {
+		return nil, err
+	}
+
+	c.maps.Add(key, stringMapEntry{value: v, expires: c.expiry(ttl)})
+
+	return v, nil
+}
+
+type stringProvider struct {
+	p     api.LazyLoadedStringProvider
+	cache *Cache
+	id    string
+	ttl   time.Duration
+}
+
+type stringMapProvider struct {
+	p     api.LazyLoadedStringMapProvider
+	cache *Cache
+	id    string
+	ttl   time.Duration
+}
+
+// provider also forwards the optional interfaces like api.HealthChecker to the wrapped provider,
+// so that wrapping does not hide them
+type provider struct {
+	stringProvider
+	stringMapProvider
+	backend api.Provider
+}
+
+// New wraps the provider so that values are fetched from the backend only once per ID and path.
+// The provider is returned as-is when caching is disabled.
+func New(c *Cache, id string, p api.Provider) api.Provider {
+	return NewWithTTL(c, id, 0, p)
+}
+
+// NewWithTTL is a variant of New that fetches the values from the backend again once they have been cached for longer than the TTL.
+// A zero TTL caches the values for the lifetime of the cache.
+func NewWithTTL(c *Cache, id string, ttl time.Duration, p api.Provider) api.Provider {
+	if Disabled() {
+		return p
+	}
+	return &provider{
+		stringProvider:    stringProvider{p: p, cache: c, id: id, ttl: ttl},
+		stringMapProvider: stringMapProvider{p: p, cache: c, id: id, ttl: ttl},
+		backend:           p,
+	}
+}
+
+// NewString is a variant of New for string providers
+func NewString(c *Cache, id string, p api.LazyLoadedStringProvider) api.LazyLoadedStringProvider {
+	if Disabled() {
+		return p
+	}
+	return &stringProvider{p: p, cache: c, id: id}
+}
+
+// NewStringMap is a variant of New for string-map providers
+func NewStringMap(c *Cache, id string, p api.LazyLoadedStringMapProvider) api.LazyLoadedStringMapProvider {
+	if Disabled() {
+		return p
+	}
+	return &stringMapProvider{p: p, cache: c, id: id}
+}
+
+func (p *stringProvider) GetString(path string) (string, error) {
+	return p.cache.getString(cacheKey(p.id, path), p.ttl, func() (string, error) {
+		return p.p.GetString(path)
+	})
+}
+
+func (p *stringMapProvider) GetStringMap(path string) (map[string]interface{}, error) {
+	return p.cache.getStringMap(cacheKey(p.id, path), p.ttl, func() (map[string]interface{}, error) {
+		return p.p.GetStringMap(path)
+	})
+}
+
+func cacheKey(id, path string) string {
+	return id + "\x00" + path
+}
+
+// Unwrap returns the wrapped provider, for optional interfaces not forwarded by the wrapper
+func (p *provider) Unwrap() api.Provider {
+	return p.backend
+}
+
+// GetStrings serves the cached values from the cache and fetches the rest with the GetStrings of the wrapped provider,
+// or GetString for each path if it is not an api.BatchProvider
+func (p *provider) GetStrings(paths []string) (map[string]string, error) {
+	sp := p.stringProvider
+	res := make(map[string]string, len(paths))
+	var missing []string
+	for _, path := range paths {
+		if v, ok := sp.cache.lookupString(cacheKey(sp.id, path)); ok {
+			res[path] = v
+			continue
+		}
+		missing = append(missing, path)
+	}
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	fetched, err := api.GetStrings(p.backend, missing)
+	for path, v := range fetched {
+		sp.cache.addString(cacheKey(sp.id, path), sp.ttl, v)
+		res[path] = v
+	}
+	return res, err
+}
+
+// HealthCheck checks the health of the wrapped provider, which is assumed to be healthy if it is not an api.HealthChecker
+func (p *provider) HealthCheck(ctx context.Context) error {
+	return api.HealthCheck(ctx, p.backend)
+}
+
+// List lists the values under the prefix with the wrapped provider, without caching them
+func (p *provider) List(prefix string) ([]string, error) {
+	lp, ok := p.backend.(api.ListProvider)
+	if !ok {
+		return nil, fmt.Errorf("provider %T does not support listing", p.backend)
+	}
+	return lp.List(prefix)
+}
+
+// DryRun returns whether the wrapped provider is in a dry run where the keys within the values are unknown
+func (p *provider) DryRun() bool {
+	return api.DryRun(p.backend)
+}
+
+// SetMetricsReporter sets the reporter of the wrapped provider, if it reports metrics.
+// Values served from the cache are not reported.
+func (p *provider) SetMetricsReporter(m api.MetricsReporter) {
+	if mr, ok := p.backend.(interface{ SetMetricsReporter(api.MetricsReporter) }); ok {
+		mr.SetMetricsReporter(m)
+	}
+}
+
+// Close closes the wrapped provider, if it holds resources like connections or watches
+func (p *provider) Close() error {
+	if c, ok := p.backend.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
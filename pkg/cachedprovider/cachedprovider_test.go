@@ -0,0 +1,221 @@
+package cachedprovider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/helmfile/vals/pkg/api"
+)
+
+type countingProvider struct {
+	err   error
+	calls int
+}
+
+func (p *countingProvider) GetString(path string) (string, error) {
+	p.calls++
+	if p.err != nil {
+		return "", p.err
+	}
+	return "value of " + path, nil
+}
+
+func (p *countingProvider) GetStringMap(path string) (map[string]interface{}, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	return map[string]interface{}{"path": path}, nil
+}
+
+func TestProvider_GetString(t *testing.T) {
+	backend := &countingProvider{}
+	c := NewCache()
+	p := New(c, "k8s", backend)
+
+	for i := 0; i < 3; i++ {
+		got, err := p.GetString("v1/Secret/ns/name/key")
+		require.NoError(t, err)
+		require.Equal(t, "value of v1/Secret/ns/name/key", got)
+	}
+	require.Equal(t, 1, backend.calls)
+
+	_, err := p.GetString("v1/Secret/ns/name/other")
+	require.NoError(t, err)
+	require.Equal(t, 2, backend.calls)
+
+	// Providers with different IDs must not share cached values
+	_, err = New(c, "k8s?kubeContext=other", backend).GetString("v1/Secret/ns/name/key")
+	require.NoError(t, err)
+	require.Equal(t, 3, backend.calls)
+}
+
+func TestProvider_GetStringMap(t *testing.T) {
+	backend := &countingProvider{}
+	p := New(NewCache(), "gcpsecrets", backend)
+
+	for i := 0; i < 3; i++ {
+		got, err := p.GetStringMap("myproject/mysecret")
+		require.NoError(t, err)
+		require.Equal(t, map[string]interface{}{"path": "myproject/mysecret"}, got)
+	}
+	require.Equal(t, 1, backend.calls)
+}
+
+func TestProvider_ErrorsAreNotCached(t *testing.T) {
+	backend := &countingProvider{err: errors.New("unavailable")}
+	p := New(NewCache(), "gcpsecrets", backend)
+
+	for i := 0; i < 3; i++ {
+		_, err := p.GetString("myproject/mysecret")
+		require.EqualError(t, err, "unavailable")
+	}
+	require.Equal(t, 3, backend.calls)
+}
+
+func TestProvider_Disabled(t *testing.T) {
+	t.Setenv(EnvDisableCache, "true")
+
+	backend := &countingProvider{}
+	p := New(NewCache(), "gcpsecrets", backend)
+
+	for i := 0; i < 3; i++ {
+		_, err := p.GetString("myproject/mysecret")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, backend.calls)
+}
+
+func TestProvider_CacheSize(t *testing.T) {
+	backend := &countingProvider{}
+	p := New(NewCacheWithSize(2), "k8s", backend)
+
+	for _, path := range []string{"a", "b", "a", "c"} {
+		_, err := p.GetString(path)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 3, backend.calls)
+
+	// b is the least recently used value, and the only one evicted
+	_, err := p.GetString("a")
+	require.NoError(t, err)
+	_, err = p.GetString("c")
+	require.NoError(t, err)
+	require.Equal(t, 3, backend.calls)
+	_, err = p.GetString("b")
+	require.NoError(t, err)
+	require.Equal(t, 4, backend.calls)
+}
+
+func TestProvider_TTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCache()
+	c.now = func() time.Time { return now }
+
+	backend := &countingProvider{}
+	p := NewWithTTL(c, "gcpsecrets?cache_ttl=1m", time.Minute, backend)
+
+	for i := 0; i < 3; i++ {
+		_, err := p.GetString("myproject/mysecret")
+		require.NoError(t, err)
+		_, err = p.GetStringMap("myproject/mysecret")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 2, backend.calls)
+
+	now = now.Add(59 * time.Second)
+	_, err := p.GetString("myproject/mysecret")
+	require.NoError(t, err)
+	require.Equal(t, 2, backend.calls)
+
+	// The values are fetched again once the TTL has elapsed, and cached for another TTL
+	now = now.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		_, err = p.GetString("myproject/mysecret")
+		require.NoError(t, err)
+		_, err = p.GetStringMap("myproject/mysecret")
+		require.NoError(t, err)
+	}
+	require.Equal(t, 4, backend.calls)
+
+	// Values cached without a TTL never expire
+	_, err = New(c, "gcpsecrets", backend).GetString("myproject/mysecret")
+	require.NoError(t, err)
+	now = now.Add(24 * time.Hour)
+	_, err = New(c, "gcpsecrets", backend).GetString("myproject/mysecret")
+	require.NoError(t, err)
+	require.Equal(t, 5, backend.calls)
+}
+
+// fullProvider implements the optional interfaces that the wrapper forwards
+type fullProvider struct {
+	countingProvider
+	healthErr error
+	batches   [][]string
+	closed    bool
+}
+
+func (p *fullProvider) GetStrings(paths []string) (map[string]string, error) {
+	p.batches = append(p.batches, paths)
+	res := map[string]string{}
+	for _, path := range paths {
+		res[path] = "value of " + path
+	}
+	return res, nil
+}
+
+func (p *fullProvider) HealthCheck(ctx context.Context) error {
+	return p.healthErr
+}
+
+func (p *fullProvider) List(prefix string) ([]string, error) {
+	return []string{prefix + "a", prefix + "b"}, nil
+}
+
+func (p *fullProvider) DryRun() bool {
+	return true
+}
+
+func (p *fullProvider) Close() error {
+	p.closed = true
+	return nil
+}
+
+func TestProvider_OptionalInterfaces(t *testing.T) {
+	backend := &fullProvider{healthErr: errors.New("unreachable")}
+	p := New(NewCache(), "gcpsecrets", backend)
+
+	require.EqualError(t, api.HealthCheck(context.Background(), p), "unreachable")
+	require.True(t, api.DryRun(p))
+
+	names, err := p.(api.ListProvider).List("myproject/prod-")
+	require.NoError(t, err)
+	require.Equal(t, []string{"myproject/prod-a", "myproject/prod-b"}, names)
+
+	// Only the values not cached yet are fetched in a batch
+	_, err = p.GetString("a")
+	require.NoError(t, err)
+	got, err := api.GetStrings(p, []string{"a", "b", "c"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"a": "value of a", "b": "value of b", "c": "value of c"}, got)
+	require.Equal(t, [][]string{{"b", "c"}}, backend.batches)
+	_, err = p.GetString("c")
+	require.NoError(t, err)
+	require.Equal(t, 1, backend.calls)
+
+	require.NoError(t, p.(interface{ Close() error }).Close())
+	require.True(t, backend.closed)
+	require.Same(t, backend, p.(interface{ Unwrap() api.Provider }).Unwrap())
+
+	// Providers without the optional interfaces are healthy, cannot list, and have nothing to close
+	p = New(NewCache(), "k8s", &countingProvider{})
+	require.NoError(t, api.HealthCheck(context.Background(), p))
+	require.False(t, api.DryRun(p))
+	_, err = p.(api.ListProvider).List("prefix")
+	require.EqualError(t, err, "provider *cachedprovider.countingProvider does not support listing")
+	require.NoError(t, p.(interface{ Close() error }).Close())
+}
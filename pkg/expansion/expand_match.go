@@ -39,7 +39,7 @@ func (e *ExpandRegexMatch) InString(s string) (string, error) {
 		ref := s[ixs[6]:ixs[7]]
 		val, err := e.Lookup(ref)
 		if err != nil {
-			return "", fmt.Errorf("expand %s: %v", ref, err)
+			return "", fmt.Errorf("expand %s: %w", ref, err)
 		}
 		sb.WriteString(s[:ixs[0]])
 		sb.WriteString(val)
@@ -0,0 +1,133 @@
+package mask
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder is what Mask replaces the secret values with
+const Placeholder = "*****"
+
+// MinLength is the length of the shortest value that is registered.
+// Shorter values like true or 443 are too likely to appear in unrelated text to be masked.
+const MinLength = 6
+
+// Registry holds the secret values resolved so far, so that they can be scrubbed from logs and other output.
+// It is safe for concurrent use.
+type Registry struct {
+	values   map[string]struct{}
+	replacer *strings.Replacer
+	m        sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{values: map[string]struct{}{}}
+}
+
+// Default is the registry the providers add the values they return to
+var Default = NewRegistry()
+
+// Add registers the value as sensitive. Values shorter than MinLength are ignored.
+func (r *Registry) Add(value string) {
+	if len(value) < MinLength {
+		return
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.values[value]; !ok {
+		r.values[value] = struct{}{}
+		r.replacer = nil
+	}
+}
+
+// AddMap registers the string values within m as sensitive, including the ones in nested maps and lists
+func (r *Registry) AddMap(m map[string]interface{}) {
+	for _, v := range m {
+		r.addValue(v)
+	}
+}
+
+func (r *Registry) addValue(v interface{}) {
+	switch t := v.(type) {
+	case string:
+		r.Add(t)
+	case map[string]interface{}:
+		r.AddMap(t)
+	case map[interface{}]interface{}:
+		for _, v := range t {
+			r.addValue(v)
+		}
+	case []interface{}:
+		for _, v := range t {
+			r.addValue(v)
+		}
+	}
+}
+
+// Reset forgets all the registered values, e.g. once the values resolved for a request are no longer in use by a long-running process
+func (r *Registry) Reset() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.values = map[string]struct{}{}
+	r.replacer = nil
+}
+
+// Values returns the registered values, the longest first
+func (r *Registry) Values() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.sorted()
+}
+
+func (r *Registry) sorted() []string {
+	values := make([]string, 0, len(r.values))
+	for v := range r.values {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	return values
+}
+
+// Mask replaces every registered value within s with Placeholder.
+// Longer values are replaced first, so that a value containing another one is masked as a whole.
+func (r *Registry) Mask(s string) string {
+	r.m.Lock()
+	if r.replacer == nil {
+		values := r.sorted()
+		oldnew := make([]string, 0, 2*len(values))
+		for _, v := range values {
+			oldnew = append(oldnew, v, Placeholder)
+		}
+		r.replacer = strings.NewReplacer(oldnew...)
+	}
+	replacer := r.replacer
+	r.m.Unlock()
+
+	return replacer.Replace(s)
+}
+
+// Add registers the value as sensitive in the Default registry
+func Add(value string) {
+	Default.Add(value)
+}
+
+// AddMap registers the string values within m as sensitive in the Default registry
+func AddMap(m map[string]interface{}) {
+	Default.AddMap(m)
+}
+
+// Reset forgets all the values registered in the Default registry
+func Reset() {
+	Default.Reset()
+}
+
+// Mask replaces every value registered in the Default registry within s with Placeholder
+func Mask(s string) string {
+	return Default.Mask(s)
+}
@@ -0,0 +1,62 @@
+package mask
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Mask(t *testing.T) {
+	r := NewRegistry()
+	require.Equal(t, "nothing to mask", r.Mask("nothing to mask"))
+
+	r.Add("p4ssw0rd")
+	r.Add("p4ssw0rd-old")
+	r.Add("")
+	r.Add("true")
+	r.Add("p4ssw0rd")
+
+	// Values shorter than MinLength are not registered
+	require.Equal(t, []string{"p4ssw0rd-old", "p4ssw0rd"}, r.Values())
+	require.Equal(t, "password=***** old=***** enabled=true", r.Mask("password=p4ssw0rd old=p4ssw0rd-old enabled=true"))
+
+	// Values added after masking are masked too
+	r.Add("adm1n-token")
+	require.Equal(t, "token=*****", r.Mask("token=adm1n-token"))
+
+	r.Reset()
+	require.Empty(t, r.Values())
+	require.Equal(t, "token=adm1n-token", r.Mask("token=adm1n-token"))
+}
+
+func TestRegistry_AddMap(t *testing.T) {
+	r := NewRegistry()
+	r.AddMap(map[string]interface{}{
+		"password": "p4ssw0rd",
+		"port":     5432,
+		"db": map[string]interface{}{
+			"token": "t0ken-value",
+		},
+		"keys": []interface{}{"first-key", map[interface{}]interface{}{"nested": "nested-key"}},
+	})
+
+	require.Equal(t, []string{"t0ken-value", "nested-key", "first-key", "p4ssw0rd"}, r.Values())
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	r := NewRegistry()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.Add("s3cret")
+			_ = r.Mask("s3cret")
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, "*****", r.Mask("s3cret"))
+}
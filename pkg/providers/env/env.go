@@ -0,0 +1,76 @@
+package env
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/helmfile/vals/pkg/api"
+)
+
+// Format: ref+env://VAR[?optional=true][&fallback_value=valuewhenvarisnotset]#/yaml_or_json_key/in/var
+type provider struct {
+	lookupEnv func(string) (string, bool)
+	fallback  *string
+	optional  bool
+}
+
+func New(cfg api.StaticConfig) *provider {
+	p := &provider{
+		lookupEnv: os.LookupEnv,
+	}
+	if v := cfg.String("optional"); v != "" {
+		p.optional, _ = strconv.ParseBool(v)
+	}
+	if v := cfg.String("fallback_value"); cfg.Exists("fallback_value") {
+		p.fallback = &v
+	}
+	return p
+}
+
+func (p *provider) GetString(key string) (string, error) {
+	v, _, err := p.getEnv(key)
+	return v, err
+}
+
+func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
+	v, ok, err := p.getEnv(key)
+	if err != nil {
+		return nil, err
+	}
+
+	m := map[string]interface{}{}
+	if !ok {
+		return m, nil
+	}
+	if err := yaml.Unmarshal([]byte(v), &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal environment variable %s: %w", strings.TrimSuffix(key, "/"), err)
+	}
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	return m, nil
+}
+
+// getEnv returns the value of the environment variable, or the fallback value if the variable is not set.
+// ok is false when the variable is not set but optional, which takes precedence over the fallback value as in gcpsecrets.
+func (p *provider) getEnv(key string) (string, bool, error) {
+	name := strings.TrimSuffix(key, "/")
+	if name == "" {
+		return "", false, fmt.Errorf("missing environment variable name")
+	}
+
+	if v, ok := p.lookupEnv(name); ok {
+		return v, true, nil
+	}
+	if p.optional {
+		return "", false, nil
+	}
+	if p.fallback != nil {
+		return *p.fallback, true, nil
+	}
+	return "", false, fmt.Errorf("environment variable %s is not set", name)
+}
@@ -0,0 +1,85 @@
+package env
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/helmfile/vals/pkg/config"
+)
+
+func newTestProvider(params map[string]interface{}, env map[string]string) *provider {
+	p := New(config.Map(params))
+	p.lookupEnv = func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	return p
+}
+
+func TestGetString(t *testing.T) {
+	env := map[string]string{"FOO": "bar", "EMPTY": ""}
+
+	tests := []struct {
+		name    string
+		key     string
+		params  map[string]interface{}
+		want    string
+		wantErr string
+	}{
+		{name: "set", key: "FOO", want: "bar"},
+		{name: "set with a trailing slash", key: "FOO/", want: "bar"},
+		{name: "set but empty", key: "EMPTY", want: ""},
+		{name: "fallback is not used when set", key: "FOO", params: map[string]interface{}{"fallback_value": "default"}, want: "bar"},
+		{name: "not set", key: "MISSING", wantErr: "environment variable MISSING is not set"},
+		{name: "not set but optional", key: "MISSING", params: map[string]interface{}{"optional": "true"}, want: ""},
+		{name: "not set with fallback", key: "MISSING", params: map[string]interface{}{"fallback_value": "default"}, want: "default"},
+		{name: "not set, optional wins over fallback", key: "MISSING", params: map[string]interface{}{"optional": "true", "fallback_value": "default"}, want: ""},
+		{name: "no name", key: "", wantErr: "missing environment variable name"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(tt.params, env)
+			got, err := p.GetString(tt.key)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetStringMap(t *testing.T) {
+	env := map[string]string{"CONFIG": "foo: bar\nbaz: 1", "INVALID": "- a"}
+
+	tests := []struct {
+		name    string
+		key     string
+		params  map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{name: "set", key: "CONFIG", want: map[string]interface{}{"foo": "bar", "baz": 1}},
+		{name: "not a map", key: "INVALID", wantErr: "failed to unmarshal environment variable INVALID"},
+		{name: "not set", key: "MISSING", wantErr: "environment variable MISSING is not set"},
+		{name: "not set but optional", key: "MISSING", params: map[string]interface{}{"optional": "true"}, want: map[string]interface{}{}},
+		{name: "not set with fallback", key: "MISSING", params: map[string]interface{}{"fallback_value": "foo: default"}, want: map[string]interface{}{"foo": "default"}},
+		{name: "not set, optional wins over fallback", key: "MISSING", params: map[string]interface{}{"optional": "true", "fallback_value": "foo: default"}, want: map[string]interface{}{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(tt.params, env)
+			got, err := p.GetStringMap(tt.key)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
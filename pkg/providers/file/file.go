@@ -2,8 +2,11 @@ package file
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"strconv"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -11,9 +14,12 @@ import (
 	"github.com/helmfile/vals/pkg/api"
 )
 
+// Format: ref+file://path/to/file[?encode=raw|base64][&optional=true][&fallback_value=valuewhenfileisnotfound]#/path/to/the/value
 type provider struct {
 	fileReader func(string) ([]byte, error)
+	Fallback   *string
 	Encode     string
+	Optional   bool
 }
 
 func New(cfg api.StaticConfig) *provider {
@@ -23,6 +29,12 @@ func New(cfg api.StaticConfig) *provider {
 	if p.Encode == "" {
 		p.Encode = "raw"
 	}
+	if v := cfg.String("optional"); v != "" {
+		p.Optional, _ = strconv.ParseBool(v)
+	}
+	if v := cfg.String("fallback_value"); cfg.Exists("fallback_value") {
+		p.Fallback = &v
+	}
 	return p
 }
 
@@ -35,6 +47,9 @@ func (p *provider) GetString(key string) (string, error) {
 	key = strings.TrimSuffix(key, "/")
 	bs, err := p.fileReader(key)
 	if err != nil {
+		if v, ok := p.missingValue(err); ok {
+			return v, nil
+		}
 		return "", err
 	}
 	switch p.Encode {
@@ -52,7 +67,11 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	key = strings.TrimSuffix(key, "/")
 	bs, err := p.fileReader(key)
 	if err != nil {
-		return nil, err
+		v, ok := p.missingValue(err)
+		if !ok {
+			return nil, err
+		}
+		bs = []byte(v)
 	}
 
 	m := map[string]interface{}{}
@@ -61,3 +80,17 @@ func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
 	}
 	return m, nil
 }
+
+// missingValue returns the value to use in place of a file that does not exist, if the fallback_value or optional params allow one
+func (p *provider) missingValue(err error) (string, bool) {
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", false
+	}
+	if p.Fallback != nil {
+		return *p.Fallback, true
+	}
+	if p.Optional {
+		return "", true
+	}
+	return "", false
+}
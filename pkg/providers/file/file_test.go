@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io/fs"
 	"testing"
 
 	"github.com/helmfile/vals/pkg/config"
@@ -28,6 +29,8 @@ func mockReadFile(name string) ([]byte, error) {
 		return []byte(yamlFileContent), nil
 	case "path/to/error_file.txt":
 		return nil, errors.New("error reading file")
+	case "path/to/missing_file.txt":
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
 	default:
 		return nil, errors.New("file not found")
 	}
@@ -206,3 +209,74 @@ func Test_provider_GetStringMap(t *testing.T) {
 		})
 	}
 }
+
+func Test_provider_MissingFile(t *testing.T) {
+	tests := []struct {
+		conf    map[string]interface{}
+		wantMap map[string]interface{}
+		name    string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Missing file",
+			conf:    map[string]interface{}{},
+			key:     "path/to/missing_file.txt",
+			wantErr: true,
+		},
+		{
+			name:    "Missing file with optional",
+			conf:    map[string]interface{}{"optional": "true"},
+			key:     "path/to/missing_file.txt",
+			want:    "",
+			wantMap: map[string]interface{}{},
+		},
+		{
+			name:    "Missing file with fallback_value",
+			conf:    map[string]interface{}{"fallback_value": "foo: default"},
+			key:     "path/to/missing_file.txt",
+			want:    "foo: default",
+			wantMap: map[string]interface{}{"foo": "default"},
+		},
+		{
+			name: "Existing file with fallback_value",
+			conf: map[string]interface{}{"fallback_value": "foo: default"},
+			key:  "path/to/file.yaml",
+			want: yamlFileContent,
+			wantMap: map[string]interface{}{
+				"foo": map[string]interface{}{
+					"bar": "baz",
+				},
+			},
+		},
+		{
+			name:    "Error reading file with optional",
+			conf:    map[string]interface{}{"optional": "true"},
+			key:     "path/to/error_file.txt",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(config.MapConfig{M: tt.conf})
+			p.fileReader = mockReadFile
+
+			got, err := p.GetString(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("provider.GetString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("provider.GetString() = %v, want %v", got, tt.want)
+			}
+
+			gotMap, err := p.GetStringMap(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("provider.GetStringMap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && fmt.Sprint(gotMap) != fmt.Sprint(tt.wantMap) {
+				t.Errorf("provider.GetStringMap() = %v, want %v", gotMap, tt.wantMap)
+			}
+		})
+	}
+}
@@ -2,36 +2,176 @@ package gcpsecrets
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	sm "cloud.google.com/go/secretmanager/apiv1"
 	smpb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"gopkg.in/yaml.v3"
 
 	"github.com/helmfile/vals/pkg/api"
+	"github.com/helmfile/vals/pkg/log"
+	"github.com/helmfile/vals/pkg/mask"
+	"github.com/helmfile/vals/pkg/retry"
 )
 
-// Format: ref+gcpsecrets://project/mykey[?version=VERSION][&fallback=valuewhenkeyisnotfound][&optional=true][&trim_nl=true]#/yaml_or_json_key/in/secret
+// EnvDefaultProject is the environment variable for the project of secrets referenced without one
+const EnvDefaultProject = "GCP_DEFAULT_PROJECT"
+
+// EnvDryRun is the environment variable for enabling the dry_run param for all the references, e.g. in CI stages without access to the secrets
+const EnvDryRun = "VALS_GCPSECRETS_DRY_RUN"
+
+// EnvCredentialsJSON is the environment variable for inline service account credentials, for environments where no credentials file can be written
+const EnvCredentialsJSON = "GOOGLE_APPLICATION_CREDENTIALS_JSON"
+
+// defaultStoreTTL is how long the payloads are kept in the store without the store_ttl param
+const defaultStoreTTL = time.Hour
+
+// The provider is safe for concurrent use. The client, the pinned versions and the rate limiter are shared by all the lookups.
+//
+// Format: ref+gcpsecrets://[project/]mykey[?version=VERSION][&project=PROJECT][&fallback=valuewhenkeyisnotfound][&optional=true][&trim_nl=true][&skip_checksum=true][&credentials_file=PATH][&credentials_json=JSON][&impersonate_service_account=EMAIL][&include_metadata=true][&retries=N][&location=LOCATION][&pin_latest=false][&format=yaml|json|dotenv][&timeout=DURATION][&rate_limit=REQUESTS_PER_SECOND][&raw=true][&versions=VERSION,...][&dry_run=true][&store_ttl=DURATION]#/yaml_or_json_key/in/secret
 type provider struct {
-	fallback *string
-	version  string
-	optional bool
-	trim_nl  bool
+	client *lazyClient
+	pinned *pinnedVersions
+	// Limits the requests per second to stay within the quota. Nil when unlimited.
+	limiter *rate.Limiter
+	// Observes each access to a secret version. Nil when not observed.
+	metrics api.MetricsReporter
+	// Caches the payloads of the secret versions across provider instances. Nil when not cached.
+	store    api.Store
+	storeTTL time.Duration
+	// The parent of the contexts of all the calls to Secret Manager
+	ctx                       context.Context
+	newClient                 func(context.Context, ...option.ClientOption) (secretManagerClient, error)
+	log                       *log.Logger
+	fallback                  *string
+	version                   string
+	versions                  []string
+	project                   string
+	format                    string
+	location                  string
+	credentialsFile           string
+	credentialsJSON           string
+	impersonateServiceAccount string
+	retries                   int
+	timeout                   time.Duration
+	optional                  bool
+	trim_nl                   bool
+	skip_checksum             bool
+	includeMetadata           bool
+	pinLatest                 bool
+	raw                       bool
+	dryRun                    bool
+}
+
+// lazyClient holds the Secret Manager client, which is created on first use and reused across lookups
+type lazyClient struct {
+	client secretManagerClient
+	m      sync.Mutex
+}
+
+// pinnedVersions holds the versions that latest resolved to, keyed by the resource name of the latest version of each secret
+type pinnedVersions struct {
+	names map[string]pinnedVersion
+	// How long a pin lasts, for the cache_ttl param. Zero pins for the lifetime of the provider.
+	ttl time.Duration
+	m   sync.Mutex
 }
 
-func New(cfg api.StaticConfig) *provider {
+// pinnedVersion is the resource name of the version that latest resolved to, along with when the pin expires
+type pinnedVersion struct {
+	name    string
+	expires time.Time
+}
+
+// expired returns whether latest should be resolved again
+func (v pinnedVersion) expired() bool {
+	return !v.expires.IsZero() && !time.Now().Before(v.expires)
+}
+
+// secretManagerClient is the subset of the Secret Manager API used by this provider, implemented by smClient
+type secretManagerClient interface {
+	AccessSecretVersion(context.Context, *smpb.AccessSecretVersionRequest, ...gax.CallOption) (*smpb.AccessSecretVersionResponse, error)
+	GetSecretVersion(context.Context, *smpb.GetSecretVersionRequest, ...gax.CallOption) (*smpb.SecretVersion, error)
+	// ListSecretsPage returns a single page of secrets along with the token of the next page
+	ListSecretsPage(context.Context, *smpb.ListSecretsRequest) ([]*smpb.Secret, string, error)
+	Close() error
+}
+
+// smClient adapts *sm.Client to secretManagerClient
+type smClient struct {
+	*sm.Client
+}
+
+func (c smClient) ListSecretsPage(ctx context.Context, req *smpb.ListSecretsRequest) ([]*smpb.Secret, string, error) {
+	var secrets []*smpb.Secret
+	next, err := iterator.NewPager(c.ListSecrets(ctx, req, noGaxRetry), int(req.GetPageSize()), req.GetPageToken()).NextPage(&secrets)
+	return secrets, next, err
+}
+
+// noGaxRetry disables the retries of the client library, so that the retries param is the only retry policy instead of multiplying the attempts
+var noGaxRetry = gax.WithRetry(func() gax.Retryer { return nil })
+
+// batchConcurrency is the maximum number of secrets fetched concurrently by GetStrings
+const batchConcurrency = 8
+
+// listPageSize is the number of secrets requested per page by List
+const listPageSize = 100
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func newClient(ctx context.Context, opts ...option.ClientOption) (secretManagerClient, error) {
+	c, err := sm.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return smClient{c}, nil
+}
+
+func New(l *log.Logger, cfg api.StaticConfig) (*provider, error) {
 	p := &provider{
-		version:  "latest",
-		optional: false,
-		fallback: nil,
-		trim_nl:  false,
+		log:       l,
+		ctx:       context.Background(),
+		newClient: newClient,
+		version:   "latest",
+		format:    "yaml",
+		retries:   retry.DefaultRetries,
+		optional:  false,
+		fallback:  nil,
+		trim_nl:   false,
+		pinLatest: true,
+		storeTTL:  defaultStoreTTL,
+		client:    &lazyClient{},
+		pinned:    &pinnedVersions{names: map[string]pinnedVersion{}},
 	}
 	if v := cfg.String("version"); v != "" {
 		p.version = v
 	}
+	if v := cfg.String("versions"); v != "" {
+		for _, version := range strings.Split(v, ",") {
+			if version = strings.TrimSpace(version); version != "" {
+				p.versions = append(p.versions, version)
+			}
+		}
+	}
+	if v := cfg.String("format"); v != "" {
+		p.format = v
+	}
 	if v := cfg.String("optional"); v != "" {
 		p.optional, _ = strconv.ParseBool(v)
 	}
@@ -41,54 +181,736 @@ func New(cfg api.StaticConfig) *provider {
 	if v := cfg.String("trim_nl"); v != "" {
 		p.trim_nl, _ = strconv.ParseBool(v)
 	}
-	return p
+	// skipChecksum is accepted too, as the param was first requested in camelCase
+	if v := cfg.String("skip_checksum"); v != "" {
+		p.skip_checksum, _ = strconv.ParseBool(v)
+	} else if v := cfg.String("skipChecksum"); v != "" {
+		p.skip_checksum, _ = strconv.ParseBool(v)
+	}
+	if v := cfg.String("retries"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid retries %q: retries must be a non-negative integer", v)
+		}
+		p.retries = n
+	}
+	if v := cfg.String("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid timeout %q: timeout must be a non-negative duration like 10s", v)
+		}
+		p.timeout = d
+	}
+	if v := cfg.String("rate_limit"); v != "" {
+		r, err := strconv.ParseFloat(v, 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("invalid rate_limit %q: rate_limit must be a positive number of requests per second", v)
+		}
+		p.limiter = rate.NewLimiter(rate.Limit(r), 1)
+	}
+	if v := cfg.String("store_ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid store_ttl %q: store_ttl must be a positive duration like 1h", v)
+		}
+		p.storeTTL = d
+	}
+	if v := cfg.String("include_metadata"); v != "" {
+		p.includeMetadata, _ = strconv.ParseBool(v)
+	}
+	if v := cfg.String("pin_latest"); v != "" {
+		p.pinLatest, _ = strconv.ParseBool(v)
+	}
+	if v := cfg.String("cache_ttl"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid cache_ttl %q: cache_ttl must be a non-negative duration like 10m", v)
+		}
+		p.pinned.ttl = d
+	}
+	if v := cfg.String("raw"); v != "" {
+		p.raw, _ = strconv.ParseBool(v)
+	}
+	p.dryRun, _ = strconv.ParseBool(os.Getenv(EnvDryRun))
+	if v := cfg.String("dry_run"); v != "" {
+		p.dryRun, _ = strconv.ParseBool(v)
+	}
+	if len(p.versions) > 0 {
+		// The payloads of the versions are returned as-is, so none of the params affecting how a payload is parsed applies
+		var conflicts []string
+		if cfg.String("version") != "" {
+			conflicts = append(conflicts, "version")
+		}
+		if cfg.String("format") != "" {
+			conflicts = append(conflicts, "format")
+		}
+		if p.raw {
+			conflicts = append(conflicts, "raw")
+		}
+		if p.includeMetadata {
+			conflicts = append(conflicts, "include_metadata")
+		}
+		if len(conflicts) > 0 {
+			return nil, fmt.Errorf("invalid versions %q: versions cannot be combined with %s", cfg.String("versions"), strings.Join(conflicts, ", "))
+		}
+	}
+	p.project = cfg.String("project")
+	if p.project == "" {
+		p.project = os.Getenv(EnvDefaultProject)
+	}
+	p.location = cfg.String("location")
+	p.credentialsFile = cfg.String("credentials_file")
+	p.credentialsJSON = cfg.String("credentials_json")
+	if p.credentialsJSON == "" && p.credentialsFile == "" {
+		p.credentialsJSON = os.Getenv(EnvCredentialsJSON)
+	}
+	p.impersonateServiceAccount = cfg.String("impersonate_service_account")
+	return p, nil
+}
+
+// WithContext returns a copy of the provider whose calls to Secret Manager are canceled when ctx is done.
+// The copy creates its own client using ctx, so it is better to call Close on it once ctx is done.
+func (p *provider) WithContext(ctx context.Context) *provider {
+	p2 := *p
+	p2.ctx = ctx
+	p2.client = &lazyClient{}
+	return &p2
+}
+
+// SetMetricsReporter makes the provider report each access to a secret version to m, including the failed ones
+func (p *provider) SetMetricsReporter(m api.MetricsReporter) {
+	p.metrics = m
+}
+
+// SetStore makes the provider consult s before accessing a secret version, and store the payloads it accesses in s for the store_ttl param.
+// Payloads are keyed by the concrete version that an alias like latest resolves to, so that a rotation takes effect right away.
+func (p *provider) SetStore(s api.Store) {
+	p.store = s
 }
 
 func (p *provider) GetString(key string) (string, error) {
-	secret, err := p.getSecret(context.TODO(), key)
+	if len(p.versions) > 0 {
+		return "", fmt.Errorf("cannot get secret %s as a string with the versions param: reference one of the versions with a fragment like #/%s", key, p.versions[0])
+	}
+	secret, resourceName, err := p.getSecret(p.ctx, key)
 	if err != nil {
 		return "", err
 	}
+	// Fallback values and dry run placeholders are not secrets
+	if resourceName != "" && !p.dryRun {
+		mask.Add(string(secret))
+	}
 	return string(secret), nil
 }
 
 func (p *provider) GetStringMap(key string) (map[string]interface{}, error) {
-	secret, err := p.getSecret(context.TODO(), key)
+	if len(p.versions) > 0 {
+		return p.getVersions(key)
+	}
+	if _, ok := formatNames[p.format]; !ok {
+		return nil, fmt.Errorf("unsupported format %q: format must be one of yaml, json or dotenv", p.format)
+	}
+	if p.dryRun && !p.raw {
+		// The keys within the secret are unknown without accessing it
+		if _, _, err := p.splitKey(key); err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{}, nil
+	}
+	secret, resourceName, err := p.getSecret(p.ctx, key)
 	if err != nil {
 		return nil, err
 	}
+	if resourceName == "" && secret == nil {
+		// The secret is optional and missing
+		return map[string]interface{}{}, nil
+	}
 	var secretMap map[string]interface{}
-	if err := yaml.Unmarshal(secret, &secretMap); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
+	if p.raw {
+		// The payload is not parsed at all, for secrets like PEM certificates that are not maps
+		secretMap = map[string]interface{}{"value": string(secret)}
+	} else {
+		secretMap, err = p.unmarshal(secret)
+		if err != nil {
+			if resourceName == "" {
+				return nil, fmt.Errorf("failed to unmarshal fallback_value %q for secret %s: fallback_value must be a %s map when a key within the secret is referenced: %w", secret, key, formatNames[p.format], err)
+			}
+			return nil, fmt.Errorf("failed to unmarshal secret %s as %s: %w", resourceName, p.format, err)
+		}
+	}
+	if secretMap == nil {
+		secretMap = map[string]interface{}{}
+	}
+	if resourceName != "" && !p.dryRun {
+		mask.AddMap(secretMap)
+	}
+
+	// The version that an alias like latest resolves to is unknown in dry runs
+	if p.includeMetadata && resourceName != "" && !p.dryRun {
+		if err := addMetadata(secretMap, resourceName); err != nil {
+			return nil, err
+		}
 	}
 	return secretMap, nil
 }
 
-func (p *provider) getSecret(ctx context.Context, key string) ([]byte, error) {
-	c, err := sm.NewClient(ctx)
+// DryRun returns whether the provider returns placeholders without accessing the secrets, so that the keys within them are unknown.
+// The keys are known with raw=true or the versions param, whose maps hold a placeholder for each key.
+func (p *provider) DryRun() bool {
+	return p.dryRun && !p.raw && len(p.versions) == 0
+}
+
+// getVersions returns the payloads of all the versions given by the versions param, keyed by version.
+// The payloads are not parsed, and a missing version is left out of the map when the secret is optional.
+func (p *provider) getVersions(key string) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for _, version := range p.versions {
+		secret, resourceName, err := p.getSecretVersion(p.ctx, key, version)
+		if err != nil {
+			return nil, err
+		}
+		if resourceName == "" && secret == nil {
+			// The version is optional and missing
+			continue
+		}
+		m[version] = string(secret)
+		// Fallback values and dry run placeholders are not secrets
+		if resourceName != "" && !p.dryRun {
+			mask.Add(string(secret))
+		}
+	}
+	return m, nil
+}
+
+// GetStrings fetches the secrets for all the keys concurrently, with at most batchConcurrency requests in flight.
+// Secrets that could be fetched are returned along with an api.BatchError for the ones that could not.
+func (p *provider) GetStrings(keys []string) (map[string]string, error) {
+	ctx := p.ctx
+
+	// Create the client upfront so that the workers share it. Dry runs need no client.
+	if !p.dryRun {
+		if _, err := p.getClient(ctx); err != nil {
+			errs := api.BatchError{}
+			for _, key := range keys {
+				errs[key] = err
+			}
+			return map[string]string{}, errs
+		}
+	}
+
+	var (
+		m    sync.Mutex
+		wg   sync.WaitGroup
+		res  = make(map[string]string, len(keys))
+		errs = api.BatchError{}
+		sem  = make(chan struct{}, batchConcurrency)
+	)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			// The same as GetString for each key, only concurrently
+			secret, err := p.GetString(key)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			res[key] = secret
+		}(key)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return res, errs
+	}
+	return res, nil
+}
+
+// formatNames are the human-readable names of the supported formats of the secret payload
+var formatNames = map[string]string{
+	"yaml":   "YAML or JSON",
+	"json":   "JSON",
+	"dotenv": "dotenv",
+}
+
+// unmarshal parses the secret payload into a map according to the format param
+func (p *provider) unmarshal(data []byte) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	switch p.format {
+	case "json":
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+	case "dotenv":
+		return parseDotenv(data)
+	default:
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// addMetadata sets the resolved version number and the full resource name of the secret version
+// under the _version and _name keys respectively.
+func addMetadata(secretMap map[string]interface{}, resourceName string) error {
+	version, err := strconv.Atoi(versionOf(resourceName))
+	if err != nil {
+		return fmt.Errorf("failed to parse the version of secret %s: %w", resourceName, err)
+	}
+	secretMap["_version"] = version
+	secretMap["_name"] = resourceName
+	return nil
+}
+
+// HealthCheck verifies that Secret Manager can be reached with the configured credentials,
+// by listing at most one secret of the project given by the project param or the GCP_DEFAULT_PROJECT envvar.
+// The project of a reference like myproject/mysecret is not known to the health check, so either of them is required.
+func (p *provider) HealthCheck(ctx context.Context) error {
+	if p.project == "" {
+		return fmt.Errorf("missing project for the health check: set the project param or the %s envvar, as the project of a reference like myproject/mysecret is not known to the health check", EnvDefaultProject)
+	}
+	c, err := p.getClient(p.ctx)
+	if err != nil {
+		return err
+	}
+	parent := p.parent(p.project)
+	if _, _, err := c.ListSecretsPage(ctx, &smpb.ListSecretsRequest{Parent: parent, PageSize: 1}); err != nil {
+		return fmt.Errorf("failed to list secrets in %s: %w", parent, classifyError(err))
+	}
+	return nil
+}
+
+// List returns the IDs of the secrets whose IDs start with the prefix, sorted, going through all the pages of secrets.
+// The project is given by the prefix like myproject/prod-, or by the project param or the GCP_DEFAULT_PROJECT envvar.
+func (p *provider) List(prefix string) ([]string, error) {
+	project, idPrefix, err := p.cutKey(prefix)
+	if err != nil {
+		return nil, err
+	}
+	parent := p.parent(project)
+	if p.dryRun {
+		p.log.Debugf("gcpsecrets: dry run: not listing secrets in %s", parent)
+		return []string{}, nil
+	}
+
+	ctx := p.ctx
+	c, err := p.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	req := &smpb.ListSecretsRequest{Parent: parent, PageSize: listPageSize}
+	if idPrefix != "" {
+		// The filter matches the prefix anywhere in the name, so the IDs are checked for the prefix below
+		req.Filter = fmt.Sprintf("name:%s", idPrefix)
+	}
+
+	ids := []string{}
+	for {
+		var secrets []*smpb.Secret
+		var next string
+		err := retry.Do(ctx, p.retries, isTransientError, func() error {
+			if p.limiter != nil {
+				if err := p.limiter.Wait(ctx); err != nil {
+					return err
+				}
+			}
+			var err error
+			secrets, next, err = c.ListSecretsPage(ctx, req)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets in %s: %w", parent, classifyError(err))
+		}
+		for _, secret := range secrets {
+			// The name of a secret is like projects/PROJECT_NUMBER/secrets/ID
+			id := secret.GetName()[strings.LastIndex(secret.GetName(), "/")+1:]
+			if strings.HasPrefix(id, idPrefix) {
+				ids = append(ids, id)
+			}
+		}
+		if next == "" {
+			break
+		}
+		req.PageToken = next
+	}
+	sort.Strings(ids)
+
+	p.log.Debugf("gcpsecrets: listed %d secrets with prefix %q in %s", len(ids), idPrefix, parent)
+
+	return ids, nil
+}
+
+// parent returns the name of the project holding the secrets, which is scoped to the location for regional secrets
+func (p *provider) parent(project string) string {
+	if p.location != "" {
+		return fmt.Sprintf("projects/%s/locations/%s", project, p.location)
+	}
+	return fmt.Sprintf("projects/%s", project)
+}
+
+// Close releases the connection held by the Secret Manager client, if any
+func (p *provider) Close() error {
+	p.client.m.Lock()
+	defer p.client.m.Unlock()
+
+	if p.client.client == nil {
+		return nil
+	}
+	err := p.client.client.Close()
+	p.client.client = nil
+	return err
+}
+
+// getClient returns the client, creating it if it does not exist yet.
+// A failure to create the client is not cached, so that the next lookup tries again.
+func (p *provider) getClient(ctx context.Context) (secretManagerClient, error) {
+	p.client.m.Lock()
+	defer p.client.m.Unlock()
+
+	if p.client.client != nil {
+		return p.client.client, nil
+	}
+
+	opts, err := p.clientOptions(ctx)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to connect: %s", err)
 		return nil, err
 	}
-	project, name, _ := strings.Cut(key, "/")
-	secret, err := c.AccessSecretVersion(ctx, &smpb.AccessSecretVersionRequest{
-		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, name, p.version),
+
+	c, err := p.newClient(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.client.client = c
+	return c, nil
+}
+
+// clientOptions returns the options for authenticating the Secret Manager client.
+// Application Default Credentials are used when none of credentials_json, credentials_file and impersonate_service_account is set.
+// Inline credentials take precedence over the credentials file.
+func (p *provider) clientOptions(ctx context.Context) ([]option.ClientOption, error) {
+	var opts []option.ClientOption
+
+	// Regional secrets are only served by the regional endpoint
+	var endpoint []option.ClientOption
+	if p.location != "" {
+		endpoint = append(endpoint, option.WithEndpoint(fmt.Sprintf("secretmanager.%s.rep.googleapis.com:443", p.location)))
+	}
+
+	if p.credentialsJSON != "" {
+		opts = append(opts, option.WithCredentialsJSON([]byte(p.credentialsJSON)))
+	} else if p.credentialsFile != "" {
+		opts = append(opts, option.WithCredentialsFile(p.credentialsFile))
+	}
+
+	if p.impersonateServiceAccount != "" {
+		ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: p.impersonateServiceAccount,
+			Scopes:          sm.DefaultAuthScopes(),
+		}, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to impersonate service account %s: %w", p.impersonateServiceAccount, err)
+		}
+		opts = []option.ClientOption{option.WithTokenSource(ts)}
+	}
+
+	return append(opts, endpoint...), nil
+}
+
+// getSecret returns the payload of the secret along with the resource name of the accessed secret version.
+// The resource name is empty when the secret could not be accessed and the optional or fallback_value param took effect.
+func (p *provider) getSecret(ctx context.Context, key string) ([]byte, string, error) {
+	return p.getSecretVersion(ctx, key, p.version)
+}
+
+func (p *provider) getSecretVersion(ctx context.Context, key, version string) ([]byte, string, error) {
+	project, name, err := p.splitKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	resourceName := p.resourceName(project, name, version)
+	if p.dryRun {
+		p.log.Debugf("gcpsecrets: dry run: not accessing secret %s", resourceName)
+		return []byte(placeholder(project, name, version)), resourceName, nil
+	}
+	c, err := p.getClient(ctx)
+	if err != nil {
+		p.log.Debugf("gcpsecrets: failed to connect: %s", err)
+		return nil, "", err
+	}
+	requestName := p.pinnedName(resourceName)
+
+	// The timeout covers all the attempts of accessing the secret, but not creating the client,
+	// which keeps using the parent context for refreshing credentials
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	// Resolving the version is cheaper than accessing it, and keeps the store from masking a rotation
+	var storeKey string
+	if p.store != nil {
+		if resolvedName, err := p.resolveVersion(ctx, c, requestName); status.Code(err) == codes.PermissionDenied {
+			// Otherwise every lookup would silently skip the store after paying for the failed request
+			return nil, "", fmt.Errorf("failed to resolve the version of secret %s for the store, which requires the secretmanager.versions.get permission: %w", resourceName, classifyError(err))
+		} else if err != nil {
+			p.log.Debugf("gcpsecrets: not using the store for secret %s, whose version could not be resolved: %s", resourceName, err)
+		} else {
+			storeKey = "gcpsecrets:" + resolvedName
+			if buf, ok := p.store.Get(storeKey); ok {
+				p.pin(resourceName, resolvedName)
+				p.log.Debugf("gcpsecrets: retrieved project=%s secret=%s version=%s from the store", project, name, versionOf(resolvedName))
+				return p.trimNewline(buf), resolvedName, nil
+			}
+			requestName = resolvedName
+		}
+	}
+
+	var secret *smpb.AccessSecretVersionResponse
+	var limitErr error
+	start := time.Now()
+	err = retry.Do(ctx, p.retries, isTransientError, func() error {
+		// Every attempt counts towards the quota
+		if p.limiter != nil {
+			if limitErr = p.limiter.Wait(ctx); limitErr != nil {
+				return limitErr
+			}
+		}
+		var err error
+		secret, err = c.AccessSecretVersion(ctx, &smpb.AccessSecretVersionRequest{
+			Name: requestName,
+		}, noGaxRetry)
+		return err
 	})
+	api.ObserveLookup(p.metrics, "gcpsecrets", resourceName, start, err)
 	if err != nil {
+		// Neither optional nor fallback_value hide a canceled or timed out call
+		if limitErr != nil && ctx.Err() == nil {
+			// The limiter gives up early when waiting would exceed the deadline
+			return nil, "", fmt.Errorf("failed to get secret %s: %w", resourceName, api.WithKind(limitErr, api.ErrTransient))
+		}
+		if ctx.Err() != nil {
+			if p.timeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, "", fmt.Errorf("timed out after %s getting secret %s: %w", p.timeout, resourceName, api.WithKind(err, api.ErrTransient))
+			}
+			return nil, "", fmt.Errorf("failed to get secret %s: %w", resourceName, err)
+		}
+
 		if p.optional {
-			return nil, nil
+			p.log.Debugf("gcpsecrets: secret %s is optional and could not be accessed: %s", resourceName, err)
+			return nil, "", nil
 		}
 
 		if p.fallback != nil {
-			return []byte(*p.fallback), nil
+			p.log.Debugf("gcpsecrets: using the fallback value for secret %s: %s", resourceName, err)
+			return []byte(*p.fallback), "", nil
 		}
 
-		return nil, fmt.Errorf("failed to get secret: %w", err)
+		return nil, "", fmt.Errorf("failed to get secret %s: %s: %w", resourceName, describeCode(status.Code(err)), classifyError(err))
 	}
 
-	buf := secret.GetPayload().GetData()
+	if !p.skip_checksum {
+		if err := verifyChecksum(secret.GetPayload()); err != nil {
+			return nil, "", fmt.Errorf("failed to verify secret %s: %w", resourceName, err)
+		}
+	}
+
+	p.pin(resourceName, secret.GetName())
+
+	p.log.Debugf("gcpsecrets: successfully retrieved project=%s secret=%s version=%s", project, name, versionOf(secret.GetName()))
+
+	if storeKey != "" {
+		p.store.Set(storeKey, secret.GetPayload().GetData(), p.storeTTL)
+	}
+
+	return p.trimNewline(secret.GetPayload().GetData()), secret.GetName(), nil
+}
+
+// trimNewline removes the trailing newline of the payload if the trim_nl param is set
+func (p *provider) trimNewline(buf []byte) []byte {
 	if p.trim_nl {
-		buf = []byte(strings.TrimSuffix(string(buf), "\n"))
+		return []byte(strings.TrimSuffix(string(buf), "\n"))
+	}
+	return buf
+}
+
+// resolveVersion returns the resource name of the concrete version that an alias like latest refers to.
+// The resource name of a concrete version is returned as-is without calling Secret Manager.
+func (p *provider) resolveVersion(ctx context.Context, c secretManagerClient, resourceName string) (string, error) {
+	if _, err := strconv.Atoi(versionOf(resourceName)); err == nil {
+		return resourceName, nil
+	}
+	var version *smpb.SecretVersion
+	err := retry.Do(ctx, p.retries, isTransientError, func() error {
+		if p.limiter != nil {
+			if err := p.limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		var err error
+		version, err = c.GetSecretVersion(ctx, &smpb.GetSecretVersionRequest{
+			Name: resourceName,
+		}, noGaxRetry)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return version.GetName(), nil
+}
+
+// splitKey returns the project and the name of the secret referenced by the key.
+// The default project is used when the key is just the name of the secret.
+func (p *provider) splitKey(key string) (string, string, error) {
+	project, name, err := p.cutKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	switch {
+	case project == "":
+		return "", "", fmt.Errorf("invalid secret %q: the project is empty", key)
+	case name == "":
+		return "", "", fmt.Errorf("invalid secret %q: the secret ID is empty", key)
+	case strings.Contains(name, "/"):
+		return "", "", fmt.Errorf("invalid secret %q: the secret ID %q must not contain a slash", key, name)
+	}
+	return project, name, nil
+}
+
+// cutKey is splitKey without the validation of the name, for prefixes of names that may be empty.
+func (p *provider) cutKey(key string) (string, string, error) {
+	if project, name, ok := strings.Cut(key, "/"); ok {
+		return project, name, nil
+	}
+	if p.project == "" {
+		return "", "", fmt.Errorf("missing project for secret %s: reference the secret as project/secret, or set the project param or the %s envvar", key, EnvDefaultProject)
+	}
+	return p.project, key, nil
+}
+
+// placeholder returns the value returned in place of the secret in dry runs
+func placeholder(project, name, version string) string {
+	return fmt.Sprintf("<gcpsecrets:%s/%s@%s>", project, name, version)
+}
+
+// versionOf returns the version part of the resource name of a secret version
+func versionOf(resourceName string) string {
+	return resourceName[strings.LastIndex(resourceName, "/")+1:]
+}
+
+// pinnedName returns the resource name of the version that latest was pinned to by a previous read of the secret,
+// so that all reads of the secret within a provider instance return the same value even if the secret is rotated meanwhile.
+// The resource name is returned as-is when there is no such version, the pin has outlived cache_ttl, or pin_latest is disabled.
+func (p *provider) pinnedName(resourceName string) string {
+	if !p.pinLatest || versionOf(resourceName) != "latest" {
+		return resourceName
+	}
+	p.pinned.m.Lock()
+	defer p.pinned.m.Unlock()
+	if pinned, ok := p.pinned.names[resourceName]; ok && !pinned.expired() {
+		return pinned.name
+	}
+	return resourceName
+}
+
+// pin records the version that latest resolved to for subsequent reads of the secret
+func (p *provider) pin(resourceName, resolvedName string) {
+	if !p.pinLatest || versionOf(resourceName) != "latest" || versionOf(resolvedName) == "latest" {
+		return
+	}
+	p.pinned.m.Lock()
+	defer p.pinned.m.Unlock()
+	if pinned, ok := p.pinned.names[resourceName]; !ok || pinned.expired() {
+		p.log.Debugf("gcpsecrets: pinned latest version of secret %s to version %s", resourceName, versionOf(resolvedName))
+		pinned = pinnedVersion{name: resolvedName}
+		if p.pinned.ttl > 0 {
+			pinned.expires = time.Now().Add(p.pinned.ttl)
+		}
+		p.pinned.names[resourceName] = pinned
+	}
+}
+
+// resourceName returns the name of the secret version, which is scoped to the location for regional secrets
+func (p *provider) resourceName(project, name, version string) string {
+	if p.location != "" {
+		return fmt.Sprintf("projects/%s/locations/%s/secrets/%s/versions/%s", project, p.location, name, version)
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, name, version)
+}
+
+// isTransientError reports whether the error is likely to go away on retry, like rate limiting or a dropped connection.
+// Errors like NotFound or PermissionDenied are permanent.
+func isTransientError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// classifyError marks the error as one of api.ErrNotFound, api.ErrPermission or api.ErrTransient according to its gRPC status code
+func classifyError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return api.WithKind(err, api.ErrNotFound)
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return api.WithKind(err, api.ErrPermission)
+	}
+	if isTransientError(err) {
+		return api.WithKind(err, api.ErrTransient)
+	}
+	return err
+}
+
+// describeCode returns a human-readable description of the gRPC status code returned by Secret Manager
+func describeCode(code codes.Code) string {
+	switch code {
+	case codes.NotFound:
+		return "secret not found"
+	case codes.PermissionDenied:
+		return "permission denied"
+	case codes.Unauthenticated:
+		return "unauthenticated"
+	case codes.ResourceExhausted:
+		return "quota exceeded"
+	case codes.Unavailable:
+		return "service unavailable"
+	case codes.DeadlineExceeded:
+		return "deadline exceeded"
+	case codes.InvalidArgument:
+		return "invalid argument"
+	case codes.FailedPrecondition:
+		return "secret version is disabled or destroyed"
+	default:
+		return strings.ToLower(code.String())
+	}
+}
+
+// verifyChecksum compares the CRC32C (Castagnoli) checksum of the payload data
+// against the one computed by Secret Manager. Payloads without a checksum are accepted as-is.
+func verifyChecksum(payload *smpb.SecretPayload) error {
+	if payload.DataCrc32C == nil {
+		return nil
+	}
+	want := payload.GetDataCrc32C()
+	got := int64(crc32.Checksum(payload.GetData(), crc32cTable))
+	if got != want {
+		return fmt.Errorf("data corruption detected: CRC32C checksum of the payload is %d but Secret Manager reported %d", got, want)
 	}
-	return buf, nil
+	return nil
 }
@@ -1,9 +1,29 @@
 package gcpsecrets
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	smpb "cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/helmfile/vals/pkg/api"
+	"github.com/helmfile/vals/pkg/cachedprovider"
 	config2 "github.com/helmfile/vals/pkg/config"
+	"github.com/helmfile/vals/pkg/log"
+	"github.com/helmfile/vals/pkg/mask"
 )
 
 func Test_New(t *testing.T) {
@@ -26,3 +46,1295 @@ func Test_New(t *testing.T) {
 		})
 	}
 }
+
+func Test_New_InvalidParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		wantErr string
+	}{
+		{"retries", map[string]interface{}{"retries": "-1"}, `invalid retries "-1": retries must be a non-negative integer`},
+		{"timeout", map[string]interface{}{"timeout": "ten"}, `invalid timeout "ten": timeout must be a non-negative duration like 10s`},
+		{"rate_limit", map[string]interface{}{"rate_limit": "abc"}, `invalid rate_limit "abc": rate_limit must be a positive number of requests per second`},
+		{"zero rate_limit", map[string]interface{}{"rate_limit": "0"}, `invalid rate_limit "0": rate_limit must be a positive number of requests per second`},
+		{"store_ttl", map[string]interface{}{"store_ttl": "forever"}, `invalid store_ttl "forever": store_ttl must be a positive duration like 1h`},
+		{"zero store_ttl", map[string]interface{}{"store_ttl": "0"}, `invalid store_ttl "0": store_ttl must be a positive duration like 1h`},
+		{"cache_ttl", map[string]interface{}{"cache_ttl": "soon"}, `invalid cache_ttl "soon": cache_ttl must be a non-negative duration like 10m`},
+		{"versions with format", map[string]interface{}{"versions": "3,4", "format": "json"}, `invalid versions "3,4": versions cannot be combined with format`},
+		{"versions with version", map[string]interface{}{"versions": "3,4", "version": "4"}, `invalid versions "3,4": versions cannot be combined with version`},
+		{"versions with version latest", map[string]interface{}{"versions": "3,4", "version": "latest"}, `invalid versions "3,4": versions cannot be combined with version`},
+		{"versions with raw and include_metadata", map[string]interface{}{"versions": "3,4", "raw": "true", "include_metadata": "true"}, `invalid versions "3,4": versions cannot be combined with raw, include_metadata`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(log.New(log.Config{Output: io.Discard}), config2.Map(tt.options))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("unexpected error: want %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func Test_New_SkipChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    bool
+	}{
+		{"default", nil, false},
+		{"skip_checksum", map[string]interface{}{"skip_checksum": "true"}, true},
+		{"skipChecksum", map[string]interface{}{"skipChecksum": "true"}, true},
+		{"skip_checksum takes precedence", map[string]interface{}{"skip_checksum": "false", "skipChecksum": "true"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mustNew(t, tt.options).skip_checksum; got != tt.want {
+				t.Errorf("skip_checksum = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_verifyChecksum(t *testing.T) {
+	data := []byte("foo: bar")
+	sum := int64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	wrong := sum + 1
+
+	tests := []struct {
+		name    string
+		payload *smpb.SecretPayload
+		wantErr bool
+	}{
+		{"matching checksum", &smpb.SecretPayload{Data: data, DataCrc32C: &sum}, false},
+		{"mismatching checksum", &smpb.SecretPayload{Data: data, DataCrc32C: &wrong}, true},
+		{"no checksum", &smpb.SecretPayload{Data: data}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyChecksum(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyChecksum() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_clientOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    int
+	}{
+		{"application default credentials", map[string]interface{}{}, 0},
+		{"credentials file", map[string]interface{}{"credentials_file": "/path/to/credentials.json"}, 1},
+		{"regional endpoint", map[string]interface{}{"location": "europe-west1"}, 1},
+		{"credentials file and regional endpoint", map[string]interface{}{"credentials_file": "/path/to/credentials.json", "location": "europe-west1"}, 2},
+		{"inline credentials", map[string]interface{}{"credentials_json": `{"type": "service_account"}`}, 1},
+		{"inline credentials and credentials file", map[string]interface{}{"credentials_json": `{"type": "service_account"}`, "credentials_file": "/path/to/credentials.json"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvCredentialsJSON, "")
+			p := mustNew(t, tt.options)
+			opts, err := p.clientOptions(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(opts) != tt.want {
+				t.Errorf("unexpected number of client options: want %d, got %d", tt.want, len(opts))
+			}
+		})
+	}
+}
+
+func Test_New_CredentialsJSON(t *testing.T) {
+	t.Setenv(EnvCredentialsJSON, `{"type": "service_account", "project_id": "fromenv"}`)
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    string
+	}{
+		{"envvar", map[string]interface{}{}, `{"type": "service_account", "project_id": "fromenv"}`},
+		{"param", map[string]interface{}{"credentials_json": `{"type": "service_account"}`}, `{"type": "service_account"}`},
+		{"credentials file", map[string]interface{}{"credentials_file": "/path/to/credentials.json"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustNew(t, tt.options)
+			if p.credentialsJSON != tt.want {
+				t.Errorf("unexpected credentials: want %q, got %q", tt.want, p.credentialsJSON)
+			}
+		})
+	}
+}
+
+func Test_addMetadata(t *testing.T) {
+	tests := []struct {
+		name         string
+		resourceName string
+		want         map[string]interface{}
+		wantErr      bool
+	}{
+		{
+			"resolved version",
+			"projects/123456/secrets/mysecret/versions/3",
+			map[string]interface{}{"foo": "bar", "_version": 3, "_name": "projects/123456/secrets/mysecret/versions/3"},
+			false,
+		},
+		{
+			"unresolved version",
+			"projects/123456/secrets/mysecret/versions/latest",
+			map[string]interface{}{"foo": "bar"},
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]interface{}{"foo": "bar"}
+			err := addMetadata(got, tt.resourceName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("addMetadata() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeClient serves secrets from memory, keyed by the resource name of the secret version.
+// The first `failures` calls fail with codes.Unavailable.
+// Calls block until the context is done when `block` is set.
+// Names found in `latest` are resolved to the name of a concrete version first, like Secret Manager does for the latest alias.
+// GetSecretVersion fails with codes.PermissionDenied when `denyGet` is set, like for a caller with only roles/secretmanager.secretAccessor.
+type fakeClient struct {
+	secrets  map[string]string
+	latest   map[string]string
+	denyGet  bool
+	failures int
+	calls    int
+	m        sync.Mutex
+	block    bool
+	listed   []*smpb.ListSecretsRequest
+	// The names of the secrets returned by ListSecretsPage, two per page
+	names []string
+}
+
+func (c *fakeClient) AccessSecretVersion(ctx context.Context, req *smpb.AccessSecretVersionRequest, _ ...gax.CallOption) (*smpb.AccessSecretVersionResponse, error) {
+	if c.block {
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.calls++
+	if c.calls <= c.failures {
+		return nil, status.Error(codes.Unavailable, "connection reset by peer")
+	}
+	name := req.GetName()
+	if resolved, ok := c.latest[name]; ok {
+		name = resolved
+	}
+	data, ok := c.secrets[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret [%s] not found or has no versions.", req.GetName())
+	}
+	return &smpb.AccessSecretVersionResponse{
+		Name:    name,
+		Payload: &smpb.SecretPayload{Data: []byte(data)},
+	}, nil
+}
+
+func (c *fakeClient) GetSecretVersion(ctx context.Context, req *smpb.GetSecretVersionRequest, _ ...gax.CallOption) (*smpb.SecretVersion, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.calls++
+	if c.denyGet {
+		return nil, status.Error(codes.PermissionDenied, "Permission 'secretmanager.versions.get' denied")
+	}
+	name := req.GetName()
+	if resolved, ok := c.latest[name]; ok {
+		name = resolved
+	}
+	if _, ok := c.secrets[name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "Secret Version [%s] not found.", req.GetName())
+	}
+	return &smpb.SecretVersion{Name: name}, nil
+}
+
+func (c *fakeClient) ListSecretsPage(ctx context.Context, req *smpb.ListSecretsRequest) ([]*smpb.Secret, string, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.calls++
+	c.listed = append(c.listed, req)
+	if c.failures > 0 {
+		c.failures--
+		return nil, "", status.Error(codes.PermissionDenied, "the caller does not have permission")
+	}
+	start, _ := strconv.Atoi(req.GetPageToken())
+	end := start + 2
+	if end >= len(c.names) {
+		end = len(c.names)
+	}
+	var secrets []*smpb.Secret
+	for _, name := range c.names[start:end] {
+		secrets = append(secrets, &smpb.Secret{Name: name})
+	}
+	var next string
+	if end < len(c.names) {
+		next = strconv.Itoa(end)
+	}
+	return secrets, next, nil
+}
+
+func (c *fakeClient) Close() error {
+	return nil
+}
+
+// mustNew creates the provider, failing the test if the options are invalid
+func mustNew(t *testing.T, options map[string]interface{}) *provider {
+	t.Helper()
+	p, err := New(log.New(log.Config{Output: io.Discard}), config2.Map(options))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return p
+}
+
+func newFakeProvider(t *testing.T, options map[string]interface{}, secrets map[string]string) *provider {
+	t.Helper()
+	p := mustNew(t, options)
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		return &fakeClient{secrets: secrets}, nil
+	}
+	return p
+}
+
+func Test_GetStringMap(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "foo: bar",
+		"projects/myproject/secrets/json/versions/latest":     `{"foo": "bar"}`,
+		"projects/myproject/secrets/dotenv/versions/latest":   "# app settings\nFOO=bar\nexport BAZ=\"qux quux\"\n",
+		"projects/myproject/secrets/cert/versions/latest":     "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n",
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		options map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "existing secret",
+			key:  "myproject/mysecret",
+			want: map[string]interface{}{"foo": "bar"},
+		},
+		{
+			name:    "missing optional secret",
+			key:     "myproject/missing",
+			options: map[string]interface{}{"optional": "true"},
+			want:    map[string]interface{}{},
+		},
+		{
+			name:    "missing secret with a YAML fallback_value",
+			key:     "myproject/missing",
+			options: map[string]interface{}{"fallback_value": "foo: baz"},
+			want:    map[string]interface{}{"foo": "baz"},
+		},
+		{
+			name:    "missing secret with an empty fallback_value",
+			key:     "myproject/missing",
+			options: map[string]interface{}{"fallback_value": ""},
+			want:    map[string]interface{}{},
+		},
+		{
+			name:    "missing secret with a non-YAML fallback_value",
+			key:     "myproject/missing",
+			options: map[string]interface{}{"fallback_value": "default-value"},
+			wantErr: `failed to unmarshal fallback_value "default-value" for secret myproject/missing: fallback_value must be a YAML or JSON map when a key within the secret is referenced`,
+		},
+		{
+			name:    "missing secret",
+			key:     "myproject/missing",
+			wantErr: "failed to get secret",
+		},
+		{
+			name:    "json secret",
+			key:     "myproject/json",
+			options: map[string]interface{}{"format": "json"},
+			want:    map[string]interface{}{"foo": "bar"},
+		},
+		{
+			name:    "yaml secret with the json format",
+			key:     "myproject/mysecret",
+			options: map[string]interface{}{"format": "json"},
+			wantErr: "failed to unmarshal secret projects/myproject/secrets/mysecret/versions/latest as json",
+		},
+		{
+			name:    "dotenv secret",
+			key:     "myproject/dotenv",
+			options: map[string]interface{}{"format": "dotenv"},
+			want:    map[string]interface{}{"FOO": "bar", "BAZ": "qux quux"},
+		},
+		{
+			name:    "yaml secret with the dotenv format",
+			key:     "myproject/mysecret",
+			options: map[string]interface{}{"format": "dotenv"},
+			wantErr: `line 1: expected KEY=VALUE, got "foo: bar"`,
+		},
+		{
+			name:    "raw secret",
+			key:     "myproject/cert",
+			options: map[string]interface{}{"raw": "true"},
+			want:    map[string]interface{}{"value": "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"},
+		},
+		{
+			name:    "missing secret with a raw fallback_value",
+			key:     "myproject/missing",
+			options: map[string]interface{}{"raw": "true", "fallback_value": "default-value"},
+			want:    map[string]interface{}{"value": "default-value"},
+		},
+		{
+			name:    "unsupported format",
+			key:     "myproject/mysecret",
+			options: map[string]interface{}{"format": "toml"},
+			wantErr: `unsupported format "toml"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := map[string]interface{}{}
+			for k, v := range tt.options {
+				options[k] = v
+			}
+			p := newFakeProvider(t, options, secrets)
+			got, err := p.GetStringMap(tt.key)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetStringMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_GetStrings(t *testing.T) {
+	secrets := map[string]string{}
+	var keys []string
+	for i := 0; i < 20; i++ {
+		secrets[fmt.Sprintf("projects/myproject/secrets/secret%d/versions/latest", i)] = fmt.Sprintf("value%d", i)
+		keys = append(keys, fmt.Sprintf("myproject/secret%d", i))
+	}
+	keys = append(keys, "myproject/missing")
+
+	p := newFakeProvider(t, nil, secrets)
+
+	got, err := p.GetStrings(keys)
+	var batchErr api.BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("expected api.BatchError, got %v", err)
+	}
+	if len(batchErr) != 1 || !strings.Contains(fmt.Sprint(batchErr["myproject/missing"]), "failed to get secret") {
+		t.Errorf("unexpected errors: %v", batchErr)
+	}
+	if len(got) != 20 || got["myproject/secret7"] != "value7" {
+		t.Errorf("unexpected values: %v", got)
+	}
+
+	got, err = api.GetStrings(p, keys[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"myproject/secret0": "value0", "myproject/secret1": "value1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected values: want %v, got %v", want, got)
+	}
+
+	// The values are masked like the ones returned by GetString
+	if got := mask.Mask("value7"); got != mask.Placeholder {
+		t.Errorf("expected value7 to be masked, got %q", got)
+	}
+
+	// The versions param fails each key like GetString does
+	p = newFakeProvider(t, map[string]interface{}{"versions": "1,2"}, secrets)
+	_, err = p.GetStrings(keys[:2])
+	if !errors.As(err, &batchErr) || len(batchErr) != 2 || !strings.Contains(fmt.Sprint(batchErr["myproject/secret0"]), "with the versions param") {
+		t.Errorf("unexpected errors: %v", err)
+	}
+}
+
+func Test_GetStringMap_Versions(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/3": "previous",
+		"projects/myproject/secrets/mysecret/versions/4": "foo: current",
+	}
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name:    "existing versions",
+			options: map[string]interface{}{"versions": "3, 4"},
+			want:    map[string]interface{}{"3": "previous", "4": "foo: current"},
+		},
+		{
+			name:    "missing optional version",
+			options: map[string]interface{}{"versions": "4,5", "optional": "true"},
+			want:    map[string]interface{}{"4": "foo: current"},
+		},
+		{
+			name:    "missing version",
+			options: map[string]interface{}{"versions": "4,5"},
+			wantErr: "failed to get secret projects/myproject/secrets/mysecret/versions/5: secret not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t, tt.options, secrets)
+			got, err := p.GetStringMap("myproject/mysecret")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetStringMap() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	p := newFakeProvider(t, map[string]interface{}{"versions": "3,4"}, secrets)
+	if _, err := p.GetString("myproject/mysecret"); err == nil || !strings.Contains(err.Error(), "reference one of the versions with a fragment like #/3") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_parseDotenv(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "comments and blank lines",
+			data: "# comment\n\nFOO=bar\n  # indented comment\nBAZ=qux\n",
+			want: map[string]interface{}{"FOO": "bar", "BAZ": "qux"},
+		},
+		{
+			name: "quoted values",
+			data: "A='single # quoted'\nB=\"double\\nquoted\"\nC=\"with \\\"escapes\\\"\" # comment\n",
+			want: map[string]interface{}{"A": "single # quoted", "B": "double\nquoted", "C": `with "escapes"`},
+		},
+		{
+			name: "export prefix, empty values, inline comments and equals signs in values",
+			data: "export A=1\nB=\nC=foo # comment\nD=a=b\n",
+			want: map[string]interface{}{"A": "1", "B": "", "C": "foo", "D": "a=b"},
+		},
+		{
+			name:    "missing equals sign",
+			data:    "FOO=bar\nBAZ\n",
+			wantErr: `line 2: expected KEY=VALUE, got "BAZ"`,
+		},
+		{
+			name:    "missing closing quote",
+			data:    "FOO=\"bar\n",
+			wantErr: `line 1: value of FOO: missing closing quote in "bar`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotenv([]byte(tt.data))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDotenv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_GetString_Retries(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "p4ssw0rd",
+	}
+
+	tests := []struct {
+		name      string
+		key       string
+		options   map[string]interface{}
+		failures  int
+		want      string
+		wantErr   codes.Code
+		wantCalls int
+	}{
+		{"succeeds after transient errors", "myproject/mysecret", map[string]interface{}{}, 2, "p4ssw0rd", codes.OK, 3},
+		{"gives up after retries", "myproject/mysecret", map[string]interface{}{"retries": "1"}, 2, "", codes.Unavailable, 2},
+		{"does not retry NotFound", "myproject/missing", map[string]interface{}{}, 0, "", codes.NotFound, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{secrets: secrets, failures: tt.failures}
+			p := mustNew(t, tt.options)
+			p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+				return client, nil
+			}
+			got, err := p.GetString(tt.key)
+			if status.Code(errors.Unwrap(err)) != tt.wantErr {
+				t.Fatalf("unexpected error: want %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("GetString() = %q, want %q", got, tt.want)
+			}
+			if client.calls != tt.wantCalls {
+				t.Errorf("unexpected number of calls: want %d, got %d", tt.wantCalls, client.calls)
+			}
+		})
+	}
+}
+
+func Test_GetString_PinLatest(t *testing.T) {
+	const latest = "projects/myproject/secrets/mysecret/versions/latest"
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/1": "v1",
+		"projects/myproject/secrets/mysecret/versions/2": "v2",
+	}
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    []string
+	}{
+		{"latest is pinned by default", nil, []string{"v1", "v1"}},
+		{"latest is re-resolved with pin_latest=false", map[string]interface{}{"pin_latest": "false"}, []string{"v1", "v2"}},
+		{"explicit versions are not pinned", map[string]interface{}{"version": "2"}, []string{"v2", "v2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{secrets: secrets, latest: map[string]string{latest: "projects/myproject/secrets/mysecret/versions/1"}}
+			p := mustNew(t, tt.options)
+			p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+				return client, nil
+			}
+
+			var got []string
+			for i := range tt.want {
+				if i > 0 {
+					// The secret is rotated between the reads
+					client.latest[latest] = "projects/myproject/secrets/mysecret/versions/2"
+				}
+				v, err := p.GetString("myproject/mysecret")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected values: want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_GetString_CacheTTL(t *testing.T) {
+	const latest = "projects/myproject/secrets/mysecret/versions/latest"
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/1": "v1",
+		"projects/myproject/secrets/mysecret/versions/2": "v2",
+	}
+
+	client := &fakeClient{secrets: secrets, latest: map[string]string{latest: "projects/myproject/secrets/mysecret/versions/1"}}
+	p := mustNew(t, map[string]interface{}{"cache_ttl": "10ms"})
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		return client, nil
+	}
+	// Cached the way vals.Runtime does for the cache_ttl param
+	cached := cachedprovider.NewWithTTL(cachedprovider.NewCache(), "mysecret", 10*time.Millisecond, p)
+
+	read := func() string {
+		t.Helper()
+		v, err := cached.GetString("myproject/mysecret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	if got := read(); got != "v1" {
+		t.Errorf("unexpected value: want v1, got %s", got)
+	}
+
+	// The pin of latest expires along with the cached value, so the rotated secret comes back
+	client.latest[latest] = "projects/myproject/secrets/mysecret/versions/2"
+	time.Sleep(20 * time.Millisecond)
+	if got := read(); got != "v2" {
+		t.Errorf("unexpected value after cache_ttl: want v2, got %s", got)
+	}
+}
+
+func Test_GetString_RateLimit(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/3": "myvalue",
+	}
+
+	p := newFakeProvider(t, map[string]interface{}{"version": "3", "rate_limit": "50"}, secrets)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := p.GetString("myproject/mysecret"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// The first request is served right away, and each of the others waits for 20ms
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the requests to be rate limited, but they took %s", elapsed)
+	}
+
+	// Waiting for the limiter is bounded by the timeout, which optional does not hide
+	p = newFakeProvider(t, map[string]interface{}{"version": "3", "rate_limit": "0.01", "timeout": "50ms", "optional": "true"}, secrets)
+	if _, err := p.GetString("myproject/mysecret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err := p.GetString("myproject/mysecret")
+	if err == nil || !strings.Contains(err.Error(), "failed to get secret projects/myproject/secrets/mysecret/versions/3: rate: Wait(n=1) would exceed context deadline") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !errors.Is(err, api.ErrTransient) {
+		t.Errorf("expected api.ErrTransient, got %v", err)
+	}
+}
+
+func Test_GetStringMap_Mask(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "db:\n  password: gcp-map-s3cret\n",
+		"projects/myproject/secrets/mysecret/versions/3":      "gcp-version-s3cret",
+	}
+
+	if _, err := newFakeProvider(t, nil, secrets).GetStringMap("myproject/mysecret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFakeProvider(t, map[string]interface{}{"versions": "3"}, secrets).GetStringMap("myproject/mysecret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFakeProvider(t, map[string]interface{}{"fallback_value": "gcp-fallback-value"}, secrets).GetString("myproject/missing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFakeProvider(t, map[string]interface{}{"dry_run": "true"}, secrets).GetString("myproject/mysecret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFakeProvider(t, map[string]interface{}{"versions": "3,4", "fallback_value": "gcp-versions-fallback"}, secrets).GetStringMap("myproject/mysecret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The values of the secrets are masked, but not the fallback values nor the dry run placeholders
+	want := "***** ***** gcp-fallback-value <gcpsecrets:myproject/mysecret@latest> gcp-versions-fallback"
+	if got := mask.Mask("gcp-map-s3cret gcp-version-s3cret gcp-fallback-value <gcpsecrets:myproject/mysecret@latest> gcp-versions-fallback"); got != want {
+		t.Errorf("unexpected masked string: want %q, got %q", want, got)
+	}
+}
+
+func Test_DryRun(t *testing.T) {
+	t.Setenv(EnvDefaultProject, "")
+	t.Setenv(EnvDryRun, "")
+
+	p := newFakeProvider(t, map[string]interface{}{"dry_run": "true"}, nil)
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		return nil, errors.New("no client must be created in dry runs")
+	}
+
+	got, err := p.GetString("myproject/mysecret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "<gcpsecrets:myproject/mysecret@latest>"; got != want {
+		t.Errorf("GetString() = %q, want %q", got, want)
+	}
+
+	gotMap, err := p.GetStringMap("myproject/mysecret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(gotMap) != 0 {
+		t.Errorf("GetStringMap() = %v, want an empty map", gotMap)
+	}
+
+	gotStrings, err := p.GetStrings([]string{"myproject/mysecret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]string{"myproject/mysecret": "<gcpsecrets:myproject/mysecret@latest>"}; !reflect.DeepEqual(gotStrings, want) {
+		t.Errorf("GetStrings() = %v, want %v", gotStrings, want)
+	}
+
+	// The reference is still validated
+	if _, err := p.GetString("mysecret"); err == nil || !strings.Contains(err.Error(), "missing project for secret mysecret") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := p.GetStringMap("mysecret"); err == nil || !strings.Contains(err.Error(), "missing project for secret mysecret") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"myproject/", "/mysecret", "myproject/a/b"} {
+		if _, err := p.GetString(key); err == nil || !strings.Contains(err.Error(), "invalid secret") {
+			t.Errorf("GetString(%q): unexpected error: %v", key, err)
+		}
+		if _, err := p.GetStringMap(key); err == nil || !strings.Contains(err.Error(), "invalid secret") {
+			t.Errorf("GetStringMap(%q): unexpected error: %v", key, err)
+		}
+	}
+
+	if !p.DryRun() {
+		t.Errorf("DryRun() = false, want true")
+	}
+
+	// No metadata is added, as the version that latest resolves to is unknown
+	p = newFakeProvider(t, map[string]interface{}{"dry_run": "true", "raw": "true", "include_metadata": "true"}, nil)
+	gotMap, err = p.GetStringMap("myproject/mysecret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]interface{}{"value": "<gcpsecrets:myproject/mysecret@latest>"}; !reflect.DeepEqual(gotMap, want) {
+		t.Errorf("GetStringMap() = %v, want %v", gotMap, want)
+	}
+	if p.DryRun() {
+		t.Errorf("DryRun() = true with raw=true, want false")
+	}
+
+	t.Setenv(EnvDryRun, "true")
+	p = newFakeProvider(t, map[string]interface{}{"versions": "3,4"}, nil)
+	gotMap, err = p.GetStringMap("myproject/mysecret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := map[string]interface{}{"3": "<gcpsecrets:myproject/mysecret@3>", "4": "<gcpsecrets:myproject/mysecret@4>"}; !reflect.DeepEqual(gotMap, want) {
+		t.Errorf("GetStringMap() = %v, want %v", gotMap, want)
+	}
+}
+
+func Test_getClient(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "myvalue",
+	}
+
+	tests := []struct {
+		name string
+		// The number of times creating the client fails before succeeding
+		failures int
+		// Whether the provider is closed between the two lookups
+		closeBetween bool
+		wantErrs     []bool
+		wantCreated  int
+	}{
+		{
+			name:        "client is reused across lookups",
+			wantErrs:    []bool{false, false},
+			wantCreated: 1,
+		},
+		{
+			name:         "client is created again after Close",
+			closeBetween: true,
+			wantErrs:     []bool{false, false},
+			wantCreated:  2,
+		},
+		{
+			name:        "failure to create the client is not cached",
+			failures:    1,
+			wantErrs:    []bool{true, false},
+			wantCreated: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t, nil, secrets)
+			var created int
+			p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+				created++
+				if created <= tt.failures {
+					return nil, errors.New("could not find default credentials")
+				}
+				return &fakeClient{secrets: secrets}, nil
+			}
+
+			for i, wantErr := range tt.wantErrs {
+				if i > 0 && tt.closeBetween {
+					if err := p.Close(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				_, err := p.GetString("myproject/mysecret")
+				if (err != nil) != wantErr {
+					t.Errorf("lookup %d: unexpected error: %v", i, err)
+				}
+			}
+			if created != tt.wantCreated {
+				t.Errorf("expected the client to be created %d times, got %d", tt.wantCreated, created)
+			}
+		})
+	}
+}
+
+func Test_GetString_Concurrent(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "myvalue",
+	}
+	p := newFakeProvider(t, nil, secrets)
+
+	var created int
+	var m sync.Mutex
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		m.Lock()
+		defer m.Unlock()
+		created++
+		return &fakeClient{secrets: secrets}, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := p.GetString("myproject/mysecret")
+			if err == nil && got != "myvalue" {
+				err = fmt.Errorf("unexpected value %q", got)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("expected the client to be created once, got %d", created)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_GetString_Context(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "foo",
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		ctx     context.Context
+		block   bool
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "timeout is not reached",
+			options: map[string]interface{}{"timeout": "1s"},
+			want:    "foo",
+		},
+		{
+			name:    "timeout is reached",
+			options: map[string]interface{}{"timeout": "10ms"},
+			block:   true,
+			wantErr: "timed out after 10ms getting secret projects/myproject/secrets/mysecret/versions/latest: rpc error: code = DeadlineExceeded",
+		},
+		{
+			name:    "timeout is reached with optional",
+			options: map[string]interface{}{"timeout": "10ms", "optional": "true"},
+			block:   true,
+			wantErr: "timed out after 10ms",
+		},
+		{
+			name:    "parent context is canceled",
+			options: map[string]interface{}{"fallback_value": "default"},
+			ctx:     canceled,
+			block:   true,
+			wantErr: "failed to get secret projects/myproject/secrets/mysecret/versions/latest: rpc error: code = Canceled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{secrets: secrets, block: tt.block}
+			p := mustNew(t, tt.options)
+			p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+				return client, nil
+			}
+			if tt.ctx != nil {
+				p = p.WithContext(tt.ctx)
+			}
+
+			got, err := p.GetString("myproject/mysecret")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unexpected value: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_HealthCheck(t *testing.T) {
+	client := &fakeClient{}
+	p := newFakeProvider(t, map[string]interface{}{"project": "myproject"}, nil)
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		return client, nil
+	}
+
+	if err := api.HealthCheck(context.Background(), p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(client.listed) != 1 || client.listed[0].GetParent() != "projects/myproject" || client.listed[0].GetPageSize() != 1 {
+		t.Errorf("unexpected requests: %v", client.listed)
+	}
+
+	client.failures = 1
+	err := p.HealthCheck(context.Background())
+	if want := "failed to list secrets in projects/myproject: rpc error: code = PermissionDenied desc = the caller does not have permission"; err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want %q, got %v", want, err)
+	}
+	if !errors.Is(err, api.ErrPermission) {
+		t.Errorf("expected api.ErrPermission, got %v", err)
+	}
+
+	t.Setenv(EnvDefaultProject, "")
+	p = newFakeProvider(t, nil, nil)
+	if err := p.HealthCheck(context.Background()); err == nil || !strings.Contains(err.Error(), "missing project for the health check") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_List(t *testing.T) {
+	client := &fakeClient{names: []string{
+		"projects/123/secrets/prod-db",
+		"projects/123/secrets/staging-db",
+		"projects/123/secrets/prod-api",
+		"projects/123/secrets/my-prod-cache",
+		"projects/123/secrets/prod-cache",
+	}}
+	p := newFakeProvider(t, map[string]interface{}{"project": "myproject"}, nil)
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		return client, nil
+	}
+
+	var lp api.ListProvider = p
+	got, err := lp.List("prod-")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"prod-api", "prod-cache", "prod-db"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+	if len(client.listed) != 3 {
+		t.Errorf("expected all the 3 pages to be listed, got %d", len(client.listed))
+	}
+	if req := client.listed[0]; req.GetParent() != "projects/myproject" || req.GetFilter() != "name:prod-" {
+		t.Errorf("unexpected request: %v", req)
+	}
+
+	client.listed = nil
+	got, err = p.List("otherproject/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 5 {
+		t.Errorf("List() = %v, want all the secrets", got)
+	}
+	if req := client.listed[0]; req.GetParent() != "projects/otherproject" || req.GetFilter() != "" {
+		t.Errorf("unexpected request: %v", req)
+	}
+
+	client.failures = 1
+	if _, err := p.List("prod-"); err == nil || !errors.Is(err, api.ErrPermission) || !strings.Contains(err.Error(), "failed to list secrets in projects/myproject") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// metricsReporter records the lookups reported to it
+type metricsReporter struct {
+	lookups []string
+	m       sync.Mutex
+}
+
+func (r *metricsReporter) ObserveLookup(provider, path string, dur time.Duration, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.lookups = append(r.lookups, fmt.Sprintf("%s %s %v", provider, path, err != nil))
+}
+
+func Test_GetString_Metrics(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/latest": "myvalue",
+	}
+
+	m := &metricsReporter{}
+	p := newFakeProvider(t, map[string]interface{}{"optional": "true"}, secrets)
+	p.SetMetricsReporter(m)
+	if _, err := p.GetString("myproject/mysecret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// A failed access is reported even when optional hides it
+	if _, err := p.GetString("myproject/missing"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{
+		"gcpsecrets projects/myproject/secrets/mysecret/versions/latest false",
+		"gcpsecrets projects/myproject/secrets/missing/versions/latest true",
+	}
+	if !reflect.DeepEqual(m.lookups, want) {
+		t.Errorf("unexpected lookups: got %v, want %v", m.lookups, want)
+	}
+
+	// Nothing is accessed in a dry run
+	m = &metricsReporter{}
+	p = newFakeProvider(t, map[string]interface{}{"dry_run": "true"}, secrets)
+	p.SetMetricsReporter(m)
+	if _, err := p.GetString("myproject/mysecret"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.lookups) != 0 {
+		t.Errorf("expected no lookups in a dry run, got %v", m.lookups)
+	}
+}
+
+// fakeStore keeps the values in memory along with their TTLs
+type fakeStore struct {
+	values map[string][]byte
+	ttls   map[string]time.Duration
+}
+
+func (s *fakeStore) Get(key string) ([]byte, bool) {
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *fakeStore) Set(key string, val []byte, ttl time.Duration) {
+	s.values[key] = val
+	s.ttls[key] = ttl
+}
+
+func Test_GetString_Store(t *testing.T) {
+	secrets := map[string]string{
+		"projects/myproject/secrets/mysecret/versions/1": "v1\n",
+		"projects/myproject/secrets/mysecret/versions/2": "v2\n",
+	}
+	store := &fakeStore{values: map[string][]byte{}, ttls: map[string]time.Duration{}}
+
+	// Each provider instance has its own client, like a short-lived process
+	get := func(options map[string]interface{}, latest string) (string, *fakeClient) {
+		t.Helper()
+		client := &fakeClient{secrets: secrets, latest: map[string]string{"projects/myproject/secrets/mysecret/versions/latest": latest}}
+		p := mustNew(t, options)
+		p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+			return client, nil
+		}
+		p.SetStore(store)
+		got, err := p.GetString("myproject/mysecret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return got, client
+	}
+
+	// The latest version is resolved, accessed and stored under the resolved version
+	got, client := get(map[string]interface{}{"store_ttl": "1h", "trim_nl": "true"}, "projects/myproject/secrets/mysecret/versions/1")
+	if got != "v1" || client.calls != 2 {
+		t.Errorf("unexpected value %q after %d calls", got, client.calls)
+	}
+	want := map[string][]byte{"gcpsecrets:projects/myproject/secrets/mysecret/versions/1": []byte("v1\n")}
+	if !reflect.DeepEqual(store.values, want) {
+		t.Errorf("unexpected stored values: %v", store.values)
+	}
+	if ttl := store.ttls["gcpsecrets:projects/myproject/secrets/mysecret/versions/1"]; ttl != time.Hour {
+		t.Errorf("unexpected ttl: %s", ttl)
+	}
+
+	// Another provider instance only resolves the version
+	got, client = get(map[string]interface{}{"trim_nl": "true"}, "projects/myproject/secrets/mysecret/versions/1")
+	if got != "v1" || client.calls != 1 {
+		t.Errorf("unexpected value %q after %d calls", got, client.calls)
+	}
+
+	// A rotation takes effect right away
+	got, client = get(map[string]interface{}{}, "projects/myproject/secrets/mysecret/versions/2")
+	if got != "v2\n" || client.calls != 2 {
+		t.Errorf("unexpected value %q after %d calls", got, client.calls)
+	}
+
+	// A concrete version is served from the store without calling Secret Manager
+	got, client = get(map[string]interface{}{"version": "1"}, "")
+	if got != "v1\n" || client.calls != 0 {
+		t.Errorf("unexpected value %q after %d calls", got, client.calls)
+	}
+
+	// Payloads are stored for an hour by default
+	store.values = map[string][]byte{}
+	got, _ = get(map[string]interface{}{}, "projects/myproject/secrets/mysecret/versions/2")
+	if got != "v2\n" {
+		t.Errorf("unexpected value %q", got)
+	}
+	if ttl := store.ttls["gcpsecrets:projects/myproject/secrets/mysecret/versions/2"]; ttl != time.Hour {
+		t.Errorf("unexpected default ttl: %s", ttl)
+	}
+
+	// Without the permission to resolve the version, the lookup fails rather than silently skipping the store
+	client = &fakeClient{secrets: secrets, latest: map[string]string{"projects/myproject/secrets/mysecret/versions/latest": "projects/myproject/secrets/mysecret/versions/2"}, denyGet: true}
+	p := mustNew(t, map[string]interface{}{"optional": "true"})
+	p.newClient = func(context.Context, ...option.ClientOption) (secretManagerClient, error) {
+		return client, nil
+	}
+	p.SetStore(store)
+	_, err := p.GetString("myproject/mysecret")
+	if err == nil || !errors.Is(err, api.ErrPermission) || !strings.Contains(err.Error(), "failed to resolve the version of secret projects/myproject/secrets/mysecret/versions/latest for the store, which requires the secretmanager.versions.get permission") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_classifyError(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want error
+	}{
+		{codes.NotFound, api.ErrNotFound},
+		{codes.PermissionDenied, api.ErrPermission},
+		{codes.Unauthenticated, api.ErrPermission},
+		{codes.Unavailable, api.ErrTransient},
+		{codes.DeadlineExceeded, api.ErrTransient},
+		{codes.InvalidArgument, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			cause := status.Error(tt.code, "boom")
+			err := classifyError(cause)
+			if err.Error() != cause.Error() {
+				t.Errorf("unexpected message: want %q, got %q", cause.Error(), err.Error())
+			}
+			for _, kind := range []error{api.ErrNotFound, api.ErrPermission, api.ErrTransient} {
+				if got := errors.Is(err, kind); got != (kind == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, kind, got)
+				}
+			}
+		})
+	}
+
+	p := newFakeProvider(t, map[string]interface{}{"retries": "0"}, nil)
+	_, err := p.GetString("myproject/missing")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Errorf("expected api.ErrNotFound, got %v", err)
+	}
+	want := "failed to get secret projects/myproject/secrets/missing/versions/latest: secret not found: rpc error: code = NotFound desc = Secret [projects/myproject/secrets/missing/versions/latest] not found or has no versions."
+	if err == nil || err.Error() != want {
+		t.Errorf("unexpected error: want %q, got %v", want, err)
+	}
+}
+
+func Test_splitKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		key         string
+		options     map[string]interface{}
+		env         string
+		wantProject string
+		wantName    string
+		wantErr     string
+	}{
+		{name: "project in the key", key: "myproject/mysecret", wantProject: "myproject", wantName: "mysecret"},
+		{name: "project in the key wins", key: "myproject/mysecret", options: map[string]interface{}{"project": "other"}, env: "another", wantProject: "myproject", wantName: "mysecret"},
+		{name: "project param", key: "mysecret", options: map[string]interface{}{"project": "other"}, env: "another", wantProject: "other", wantName: "mysecret"},
+		{name: "default project envvar", key: "mysecret", env: "another", wantProject: "another", wantName: "mysecret"},
+		{name: "no project", key: "mysecret", wantErr: "missing project for secret mysecret: reference the secret as project/secret, or set the project param or the GCP_DEFAULT_PROJECT envvar"},
+		{name: "empty project", key: "/mysecret", options: map[string]interface{}{"project": "other"}, wantErr: `invalid secret "/mysecret": the project is empty`},
+		{name: "empty secret ID", key: "myproject/", wantErr: `invalid secret "myproject/": the secret ID is empty`},
+		{name: "empty secret ID with project param", key: "", options: map[string]interface{}{"project": "other"}, wantErr: `invalid secret "": the secret ID is empty`},
+		{name: "slash in secret ID", key: "myproject/a/b", wantErr: `invalid secret "myproject/a/b": the secret ID "a/b" must not contain a slash`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvDefaultProject, tt.env)
+			p := mustNew(t, tt.options)
+			project, name, err := p.splitKey(tt.key)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("unexpected error: want %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if project != tt.wantProject || name != tt.wantName {
+				t.Errorf("splitKey(%q) = %s, %s, want %s, %s", tt.key, project, name, tt.wantProject, tt.wantName)
+			}
+		})
+	}
+}
+
+func Test_resourceName(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		want    string
+	}{
+		{"global", map[string]interface{}{}, "projects/myproject/secrets/mysecret/versions/latest"},
+		{"global with version", map[string]interface{}{"version": "3"}, "projects/myproject/secrets/mysecret/versions/3"},
+		{"regional", map[string]interface{}{"location": "europe-west1"}, "projects/myproject/locations/europe-west1/secrets/mysecret/versions/latest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := mustNew(t, tt.options)
+			got := p.resourceName("myproject", "mysecret", p.version)
+			if got != tt.want {
+				t.Errorf("resourceName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,42 +2,184 @@ package k8s
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 
 	"github.com/helmfile/vals/pkg/api"
 	"github.com/helmfile/vals/pkg/log"
+	"github.com/helmfile/vals/pkg/mask"
+	"github.com/helmfile/vals/pkg/retry"
 )
 
+const (
+	// The default timeout for each request to the Kubernetes API server
+	defaultTimeout = 30 * time.Second
+
+	// The name that makes the provider select the object by the labelSelector URI parameter
+	nameSelectedByLabels = "-"
+
+	// The namespace used when the path has none and the kube context sets none either
+	defaultNamespace = "default"
+
+	// The file holding the namespace of the pod when using the in-cluster config
+	inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// The provider is safe for concurrent use. The clientset, the namespace of the kube context and the watches are shared by all the lookups.
 type provider struct {
-	log            *log.Logger
-	KubeConfigPath string
-	KubeContext    string
-	InCluster      bool
+	// The clientset and the namespace of the kube context are resolved on first use and reused across lookups
+	clientset             kubernetes.Interface
+	clientsetErr          error
+	contextNamespace      string
+	clientsetM            sync.Mutex
+	watchers              watchers
+	log                   *log.Logger
+	Fallback              *string
+	KubeConfigPath        string
+	KubeConfigContent     string
+	KubeContext           string
+	Server                string
+	Token                 string
+	CACert                string
+	LabelSelector         string
+	Encode                string
+	KubeConfigFromSecret  string
+	Impersonate           rest.ImpersonationConfig
+	Timeout               time.Duration
+	Retries               int
+	InCluster             bool
+	InsecureSkipTLSVerify bool
+	Optional              bool
+	Watch                 bool
+	// The kube contexts of the kubeContexts URI parameter, tried in order with the provider for each
+	KubeContexts []string
+	contexts     []*provider
+	// Observes each fetch of an object. Nil when not observed.
+	metrics api.MetricsReporter
 }
 
 func New(l *log.Logger, cfg api.StaticConfig) (*provider, error) {
+	if v := cfg.String("kubeContexts"); v != "" {
+		return newFailover(l, cfg, v)
+	}
+
 	p := &provider{
-		log: l,
+		log:     l,
+		Timeout: defaultTimeout,
+		Retries: retry.DefaultRetries,
 	}
 	var err error
 
+	if v := cfg.String("timeout"); v != "" {
+		p.Timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timeout %s: %s", v, err)
+		}
+	}
+
+	if v := cfg.String("retries"); v != "" {
+		p.Retries, err = strconv.Atoi(v)
+		if err != nil || p.Retries < 0 {
+			return nil, fmt.Errorf("Invalid retries %s. Retries must be a non-negative integer.", v)
+		}
+	}
+
+	if v := cfg.String("optional"); v != "" {
+		p.Optional, _ = strconv.ParseBool(v)
+	}
+	if v := cfg.String("fallback_value"); cfg.Exists("fallback_value") {
+		p.Fallback = &v
+	}
+
+	p.LabelSelector = cfg.String("labelSelector")
+
+	if v := cfg.String("watch"); v != "" {
+		p.Watch, _ = strconv.ParseBool(v)
+	}
+
+	p.Encode = cfg.String("encode")
+	if p.Encode == "" {
+		p.Encode = "raw"
+	}
+	if p.Encode != "raw" && p.Encode != "base64" {
+		return nil, fmt.Errorf("Unsupported encode parameter: '%s'.", p.Encode)
+	}
+
+	p.Impersonate, err = getImpersonationConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	p.InCluster = cfg.Exists("inCluster")
 
+	if v := cfg.String("kubeConfigFromSecret"); v != "" {
+		if _, _, _, err := parseKubeConfigFromSecret(v); err != nil {
+			return nil, err
+		}
+		p.KubeConfigFromSecret = v
+	}
+
+	p.Server = cfg.String("server")
+	p.Token = cfg.String("token")
+	if p.Server != "" || p.Token != "" {
+		if err := p.validateServerConfig(cfg); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	// The kubeconfig can be passed inline where no file can be written
+	if v := cfg.String("kubeConfigContent"); v != "" {
+		if cfg.String("kubeConfigPath") != "" {
+			return nil, fmt.Errorf("kubeConfigPath and kubeConfigContent URI parameters are mutually exclusive.")
+		}
+		if p.InCluster {
+			return nil, fmt.Errorf("inCluster and kubeConfigContent URI parameters are mutually exclusive.")
+		}
+		if _, err := clientcmd.Load([]byte(v)); err != nil {
+			return nil, fmt.Errorf("Unable to parse the kubeConfigContent URI parameter: %s", err)
+		}
+		p.KubeConfigContent = v
+		p.KubeContext = getKubeContext(cfg)
+		return p, nil
+	}
+
 	if !p.InCluster {
 		p.KubeConfigPath, err = getKubeConfigPath(cfg)
 		if err != nil {
-			p.log.Debugf("vals-k8s: Unable to get a valid kubeConfig path: %s", err)
-			return nil, err
+			// Fall back to the in-cluster config only when no kubeconfig was explicitly requested
+			if cfg.String("kubeConfigPath") == "" && os.Getenv("KUBECONFIG") == "" && inClusterConfigAvailable() {
+				p.log.Debugf("vals-k8s: No kubeConfig path was found. Using in-cluster config.")
+				p.InCluster = true
+			} else {
+				p.log.Debugf("vals-k8s: Unable to get a valid kubeConfig path: %s", err)
+				return nil, err
+			}
 		}
+	}
 
+	if p.InCluster {
+		if getKubeContext(cfg) != "" {
+			p.log.Debugf("vals-k8s: kubeContext is ignored when using in-cluster config.")
+		}
+	} else {
 		p.KubeContext = getKubeContext(cfg)
 
 		if p.KubeContext == "" {
@@ -48,6 +190,156 @@ func New(l *log.Logger, cfg api.StaticConfig) (*provider, error) {
 	return p, nil
 }
 
+// Build the provider trying each of the comma-separated kube contexts in order, for active/passive clusters.
+// Each context gets its own provider, configured as if the kubeContext URI parameter were set to it.
+func newFailover(l *log.Logger, cfg api.StaticConfig, kubeContexts string) (*provider, error) {
+	if getKubeContext(cfg) != "" {
+		return nil, fmt.Errorf("kubeContext and kubeContexts URI parameters are mutually exclusive.")
+	}
+	if cfg.String("server") != "" || cfg.String("token") != "" {
+		return nil, fmt.Errorf("server and kubeContexts URI parameters are mutually exclusive.")
+	}
+	if cfg.Exists("inCluster") {
+		return nil, fmt.Errorf("inCluster and kubeContexts URI parameters are mutually exclusive.")
+	}
+
+	p := &provider{log: l}
+	for _, kubeContext := range strings.Split(kubeContexts, ",") {
+		if kubeContext = strings.TrimSpace(kubeContext); kubeContext == "" {
+			return nil, fmt.Errorf("Invalid kubeContexts %s. It must be a comma-separated list of kube contexts like prod-east,prod-west.", kubeContexts)
+		}
+		sub, err := New(l, kubeContextConfig{StaticConfig: cfg, kubeContext: kubeContext})
+		if err != nil {
+			return nil, err
+		}
+		p.KubeContexts = append(p.KubeContexts, kubeContext)
+		p.contexts = append(p.contexts, sub)
+	}
+
+	return p, nil
+}
+
+// The config of one of the kubeContexts, which reads as if the kubeContext URI parameter were set to the context
+type kubeContextConfig struct {
+	api.StaticConfig
+	kubeContext string
+}
+
+func (c kubeContextConfig) String(path ...string) string {
+	if len(path) == 1 {
+		switch path[0] {
+		case "kubeContext":
+			return c.kubeContext
+		case "kubeContexts":
+			return ""
+		}
+	}
+	return c.StaticConfig.String(path...)
+}
+
+func (c kubeContextConfig) Exists(path ...string) bool {
+	if len(path) == 1 {
+		switch path[0] {
+		case "kubeContext":
+			return true
+		case "kubeContexts":
+			return false
+		}
+	}
+	return c.StaticConfig.Exists(path...)
+}
+
+// Call f with the provider for each of the kubeContexts in order, until one succeeds.
+// Only errors reaching the cluster, like a refused connection or a TLS failure, advance to the next context.
+// Any other error, like a NotFound, a permission error or an unparsable key, is returned as is,
+// so that an error in the first reachable cluster is not masked by the next one.
+func (p *provider) failover(what string, f func(*provider) error) error {
+	var errs []error
+	for i, sub := range p.contexts {
+		err := f(sub)
+		if err == nil {
+			return nil
+		}
+		if !isConnectionError(err) {
+			return err
+		}
+		if i < len(p.contexts)-1 {
+			p.log.Debugf("vals-k8s: Unable to get %s with kubeContext %s. Trying kubeContext %s: %s", what, p.KubeContexts[i], p.KubeContexts[i+1], err)
+		}
+		errs = append(errs, fmt.Errorf("kubeContext %s: %w", p.KubeContexts[i], err))
+	}
+	return fmt.Errorf("Unable to get %s with any of the kubeContexts %s: %w", what, strings.Join(p.KubeContexts, ", "), errors.Join(errs...))
+}
+
+// Build the impersonation config from the impersonateUser, impersonateGroups and impersonateServiceAccount URI parameters,
+// the same way as kubectl's --as, --as-group and --as=system:serviceaccount:<namespace>:<name> flags
+func getImpersonationConfig(cfg api.StaticConfig) (rest.ImpersonationConfig, error) {
+	var impersonate rest.ImpersonationConfig
+
+	user := cfg.String("impersonateUser")
+	serviceAccount := cfg.String("impersonateServiceAccount")
+
+	if user != "" && serviceAccount != "" {
+		return impersonate, fmt.Errorf("impersonateUser and impersonateServiceAccount URI parameters are mutually exclusive.")
+	}
+
+	impersonate.UserName = user
+
+	if serviceAccount != "" {
+		namespace, name, ok := strings.Cut(serviceAccount, ":")
+		if !ok || namespace == "" || name == "" {
+			return impersonate, fmt.Errorf("Invalid impersonateServiceAccount %s. impersonateServiceAccount must be in the format <namespace>:<name>", serviceAccount)
+		}
+		impersonate.UserName = fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name)
+	}
+
+	for _, group := range strings.Split(cfg.String("impersonateGroups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			impersonate.Groups = append(impersonate.Groups, group)
+		}
+	}
+
+	if len(impersonate.Groups) > 0 && impersonate.UserName == "" {
+		return impersonate, fmt.Errorf("impersonateGroups URI parameter requires either impersonateUser or impersonateServiceAccount to be set.")
+	}
+
+	return impersonate, nil
+}
+
+// Validate the server, token, caCert and insecureSkipTLSVerify URI parameters, used in place of a kubeconfig
+func (p *provider) validateServerConfig(cfg api.StaticConfig) error {
+	if p.Server == "" || p.Token == "" {
+		return fmt.Errorf("server and token URI parameters must be set together.")
+	}
+	if p.InCluster {
+		return fmt.Errorf("server and inCluster URI parameters are mutually exclusive.")
+	}
+
+	p.CACert = cfg.String("caCert")
+	if v := cfg.String("insecureSkipTLSVerify"); v != "" {
+		p.InsecureSkipTLSVerify, _ = strconv.ParseBool(v)
+	}
+	if p.CACert != "" && p.InsecureSkipTLSVerify {
+		return fmt.Errorf("caCert and insecureSkipTLSVerify URI parameters are mutually exclusive.")
+	}
+	if p.CACert != "" {
+		if _, err := os.Stat(p.CACert); err != nil {
+			return fmt.Errorf("caCert URI parameter is set but path %s does not exist.", p.CACert)
+		}
+	}
+
+	if cfg.String("kubeConfigPath") != "" || cfg.String("kubeConfigContent") != "" || getKubeContext(cfg) != "" {
+		p.log.Debugf("vals-k8s: kubeConfigPath, kubeConfigContent and kubeContext are ignored when using the server URI parameter.")
+	}
+
+	return nil
+}
+
+// Report whether the environment looks like a pod with the in-cluster config available
+func inClusterConfigAvailable() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
 func getKubeConfigPath(cfg api.StaticConfig) (string, error) {
 	// Use kubeConfigPath from URI parameters if specified
 	if cfg.String("kubeConfigPath") != "" {
@@ -79,32 +371,141 @@ func getKubeConfigPath(cfg api.StaticConfig) (string, error) {
 	return "", fmt.Errorf("No path was found in any of the following: kubeContext URI param, KUBECONFIG environment variable, or default path %s does not exist.", defaultPath)
 }
 
+// A path parsed by parsePath
+type objectPath struct {
+	apiVersion string
+	kind       string
+	// Empty when the namespace is omitted to use the namespace of the kube context
+	namespace string
+	name      string
+	key       string
+}
+
+// Parse the path to a key within an object when withKey is true, or to the whole object otherwise.
+// Each mistake in the path is reported in its own sentence, like an empty name or a key containing a slash.
+func parsePath(path string, withKey bool) (objectPath, error) {
+	format := "<apiVersion>/<kind>/<namespace>/<name>"
+	segments := 4
+	if withKey {
+		format += "/<key>"
+		segments = 5
+	}
+
+	splits := strings.Split(path, "/")
+	count := len(splits)
+
+	if !withKey && count == 5 {
+		if splits[4] == "" {
+			return objectPath{}, fmt.Errorf("Invalid path %s. The path must not end with a slash. Path must be in the format %s", path, format)
+		}
+		return objectPath{}, fmt.Errorf("Invalid path %s. A path to a single key must be fetched as a string, not a map. Path must be in the format %s", path, format)
+	}
+
+	if count > segments {
+		msg := fmt.Sprintf("Invalid path %s. Path must be in the format %s, but it has %d segments.", path, format, count)
+		if withKey {
+			msg += " Keys cannot contain slashes, so a key like a/b cannot be referenced."
+		}
+		return objectPath{}, errors.New(msg)
+	}
+
+	if count < segments-1 {
+		return objectPath{}, fmt.Errorf("Invalid path %s. Path must be in the format %s, or the same without <namespace> to use the namespace of the kube context, but it has only %d segments.", path, format, count)
+	}
+
+	// The namespace can be omitted to use the namespace of the kube context
+	namespaceOmitted := count == segments-1
+	if namespaceOmitted {
+		splits = append([]string{splits[0], splits[1], ""}, splits[2:]...)
+	}
+
+	parsed := objectPath{
+		apiVersion: splits[0],
+		kind:       splits[1],
+		namespace:  splits[2],
+		name:       splits[3],
+	}
+	if withKey {
+		parsed.key = splits[4]
+	}
+
+	var problems []string
+	if parsed.apiVersion == "" {
+		problems = append(problems, "The apiVersion is empty.")
+	}
+	if parsed.kind == "" {
+		problems = append(problems, "The kind is empty.")
+	}
+	if parsed.namespace == "" && !namespaceOmitted {
+		problems = append(problems, "The namespace is empty. Omit it along with its slash to use the namespace of the kube context.")
+	}
+	if parsed.name == "" {
+		problems = append(problems, "The name is empty.")
+	}
+	if withKey && parsed.key == "" {
+		problems = append(problems, "The key is empty. Remove the trailing slash when referencing the whole object with a fragment.")
+	}
+	if len(problems) > 0 {
+		return objectPath{}, fmt.Errorf("Invalid path %s. %s Path must be in the format %s", path, strings.Join(problems, " "), format)
+	}
+
+	if parsed.apiVersion != "v1" {
+		return objectPath{}, fmt.Errorf("Invalid apiVersion %s. Only apiVersion v1 is supported at this time.", parsed.apiVersion)
+	}
+
+	return parsed, nil
+}
+
+// SetMetricsReporter makes the provider report each fetch of an object from the Kubernetes API server to m, including the failed ones.
+// Objects served from a watch are not reported, as they are read from memory.
+func (p *provider) SetMetricsReporter(m api.MetricsReporter) {
+	p.metrics = m
+	for _, sub := range p.contexts {
+		sub.SetMetricsReporter(m)
+	}
+}
+
 func (p *provider) GetString(path string) (string, error) {
-	separator := "/"
-	splits := strings.Split(path, separator)
+	parsed, err := parsePath(path, true)
+	if err != nil {
+		return "", err
+	}
 
-	if len(splits) != 5 {
-		return "", fmt.Errorf("Invalid path %s. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>", path)
+	if len(p.contexts) > 0 {
+		var value string
+		err := p.failover(path, func(sub *provider) error {
+			var err error
+			value, err = sub.GetString(path)
+			return err
+		})
+		return value, err
 	}
 
-	apiVersion := splits[0]
-	kind := splits[1]
-	namespace := splits[2]
-	name := splits[3]
-	key := splits[4]
+	kind, name, key := parsed.kind, parsed.name, parsed.key
 
-	if apiVersion != "v1" {
-		return "", fmt.Errorf("Invalid apiVersion %s. Only apiVersion v1 is supported at this time.", apiVersion)
+	namespace, err := p.resolveNamespace(parsed.namespace, path)
+	if err != nil {
+		return "", err
 	}
 
-	objectData, err := getObject(kind, namespace, name, p.KubeConfigPath, p.KubeContext, p.InCluster, context.Background())
+	objectData, err := p.fetchObject(kind, namespace, name)
 	if err != nil {
-		return "", fmt.Errorf("Unable to get %s %s/%s: %s", kind, namespace, name, err)
+		if isMissing(err) {
+			if v, ok := p.missingValue(); ok {
+				p.log.Debugf("vals-k8s: %s %s/%s does not exist. Using the fallback value.", kind, namespace, name)
+				return v, nil
+			}
+		}
+		return "", err
 	}
 
 	object, exists := objectData[key]
 	if !exists {
-		return "", fmt.Errorf("Key %s does not exist in %s/%s", key, namespace, name)
+		if v, ok := p.missingValue(); ok {
+			p.log.Debugf("vals-k8s: Key %s does not exist in %s/%s. Using the fallback value.", key, namespace, name)
+			return v, nil
+		}
+		return "", api.WithKind(fmt.Errorf("Key %s does not exist in %s/%s", key, namespace, name), api.ErrNotFound)
 	}
 
 	// Print success message with kubeContext if provided
@@ -114,11 +515,419 @@ func (p *provider) GetString(path string) (string, error) {
 	}
 	p.log.Debugf(message)
 
-	return object, nil
+	value := p.encode(object)
+	if kind == "Secret" {
+		mask.Add(value)
+	}
+
+	return value, nil
 }
 
 func (p *provider) GetStringMap(path string) (map[string]interface{}, error) {
-	return nil, fmt.Errorf("This provider does not support values from URI fragments")
+	if len(p.contexts) > 0 {
+		// Fail early on an invalid path, which is invalid with any of the contexts
+		if _, err := parsePath(path, strings.Count(path, "/") == 4); err != nil {
+			return nil, err
+		}
+		var res map[string]interface{}
+		err := p.failover(path, func(sub *provider) error {
+			var err error
+			res, err = sub.GetStringMap(path)
+			return err
+		})
+		return res, err
+	}
+
+	// A path to a key is fetched as a document, for a fragment referencing a field within it
+	if strings.Count(path, "/") == 4 {
+		return p.getDocument(path)
+	}
+
+	parsed, err := parsePath(path, false)
+	if err != nil {
+		return nil, err
+	}
+	kind, name := parsed.kind, parsed.name
+
+	namespace, err := p.resolveNamespace(parsed.namespace, path)
+	if err != nil {
+		return nil, err
+	}
+
+	objectData, err := p.fetchObject(kind, namespace, name)
+	if err != nil {
+		if isMissing(err) && parsed.namespace != "" {
+			// A path to a key without the namespace, like v1/Secret/mysecret/config.yaml, reads as the name within the namespace
+			return nil, fmt.Errorf("%w. If %s is the name of the %s and %s is a key, include the namespace like %s/%s/<namespace>/%s/%s to reference a field within the key.", err, parsed.namespace, kind, name, parsed.apiVersion, kind, parsed.namespace, name)
+		}
+		return nil, err
+	}
+
+	res := make(map[string]interface{}, len(objectData))
+	for k, v := range objectData {
+		res[k] = p.encode(v)
+	}
+	if kind == "Secret" {
+		mask.AddMap(res)
+	}
+
+	// Print success message with kubeContext if provided
+	message := fmt.Sprintf("vals-k8s: Retrieved %s: %s/%s", kind, namespace, name)
+	if p.KubeContext != "" {
+		message += fmt.Sprintf(" (KubeContext: %s)", p.KubeContext)
+	}
+	p.log.Debugf(message)
+
+	return res, nil
+}
+
+// Encode the value as specified by the encode URI parameter.
+// base64 keeps binary values like keystores intact through text-based pipelines.
+func (p *provider) encode(value string) string {
+	if p.Encode == "base64" {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	return value
+}
+
+// Fetch the key and parse its contents as a YAML or JSON document, so that a fragment like #/path/to/field references a field within it.
+// The fallback_value is parsed the same way when the object or the key does not exist.
+func (p *provider) getDocument(path string) (map[string]interface{}, error) {
+	parsed, err := parsePath(path, true)
+	if err != nil {
+		return nil, err
+	}
+	kind, name, key := parsed.kind, parsed.name, parsed.key
+
+	if p.Encode != "raw" {
+		return nil, fmt.Errorf("encode=%s cannot be used with a fragment into key %s, whose contents are parsed as YAML or JSON.", p.Encode, key)
+	}
+
+	namespace, err := p.resolveNamespace(parsed.namespace, path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data string
+	var fallback bool
+	objectData, err := p.fetchObject(kind, namespace, name)
+	if err != nil {
+		v, ok := p.missingValue()
+		if !isMissing(err) || !ok {
+			return nil, err
+		}
+		p.log.Debugf("vals-k8s: %s %s/%s does not exist. Using the fallback value.", kind, namespace, name)
+		data, fallback = v, true
+	} else if v, exists := objectData[key]; exists {
+		data = v
+	} else if v, ok := p.missingValue(); ok {
+		p.log.Debugf("vals-k8s: Key %s does not exist in %s/%s. Using the fallback value.", key, namespace, name)
+		data, fallback = v, true
+	} else {
+		return nil, api.WithKind(fmt.Errorf("Key %s does not exist in %s/%s", key, namespace, name), api.ErrNotFound)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+		if fallback {
+			return nil, fmt.Errorf("Unable to parse fallback_value %q as YAML or JSON, which is required for a fragment into key %s: %s", data, key, err)
+		}
+		return nil, fmt.Errorf("Unable to parse key %s of %s %s/%s as YAML or JSON: %s", key, kind, namespace, name, err)
+	}
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+
+	if kind == "Secret" && !fallback {
+		mask.AddMap(doc)
+	}
+
+	p.log.Debugf("vals-k8s: Retrieved %s: %s/%s/%s as a document", kind, namespace, name, key)
+
+	return doc, nil
+}
+
+// Report whether the error is due to a missing object, like a NotFound from the API server or no object matching the label selector.
+// Errors building the clientset are never due to a missing object, even if the kubeconfig Secret does not exist.
+func isMissing(err error) bool {
+	var ce clientsetError
+	return errors.Is(err, api.ErrNotFound) && !errors.As(err, &ce)
+}
+
+// Return the value to use in place of a missing object or key, if the fallback_value or optional params allow one
+func (p *provider) missingValue() (string, bool) {
+	if p.Fallback != nil {
+		return *p.Fallback, true
+	}
+	if p.Optional {
+		return "", true
+	}
+	return "", false
+}
+
+// Fetch the object, retrying on transient errors and giving up once the configured timeout has elapsed
+func (p *provider) fetchObject(kind string, namespace string, name string) (map[string]string, error) {
+	clientset, err := p.getClientset()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	if p.Watch {
+		return p.getWatchedObject(clientset, kind, namespace, name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	var objectData map[string]string
+	start := time.Now()
+	err = retry.Do(ctx, p.Retries, isTransientError, func() error {
+		var err error
+		objectData, err = getObjectWithClientset(clientset, kind, namespace, name, p.LabelSelector, ctx)
+		if err != nil && isTransientError(err) {
+			p.log.Debugf("vals-k8s: Transient error getting %s %s/%s: %s", kind, namespace, name, err)
+		}
+		return err
+	})
+	api.ObserveLookup(p.metrics, "k8s", fmt.Sprintf("v1/%s/%s/%s", kind, namespace, name), start, err)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("Timed out after %s getting %s %s/%s: %w", p.Timeout, kind, namespace, name, api.WithKind(err, api.ErrTransient))
+		}
+		return nil, fmt.Errorf("Unable to get %s %s/%s: %w", kind, namespace, name, classifyError(err))
+	}
+
+	return objectData, nil
+}
+
+// Mark the error as one of api.ErrNotFound, api.ErrPermission or api.ErrTransient, if it is any of them
+func classifyError(err error) error {
+	switch {
+	case apierrors.IsNotFound(err):
+		return api.WithKind(err, api.ErrNotFound)
+	case apierrors.IsForbidden(err) || apierrors.IsUnauthorized(err):
+		return api.WithKind(err, api.ErrPermission)
+	case isTransientError(err):
+		return api.WithKind(err, api.ErrTransient)
+	default:
+		return err
+	}
+}
+
+// Build the clientset and resolve the namespace of the kube context on first use, so that the kubeconfig is read only once per provider.
+// A permanent error building the clientset is returned on every call. A transient one, like a timeout fetching the kubeconfig Secret,
+// is not cached, so that the next call tries again.
+func (p *provider) getClientset() (kubernetes.Interface, error) {
+	p.clientsetM.Lock()
+	defer p.clientsetM.Unlock()
+
+	if p.clientset != nil || p.clientsetErr != nil {
+		return p.clientset, p.clientsetErr
+	}
+
+	clientset, namespace, err := p.buildClientset()
+	if err != nil {
+		err = clientsetError{err}
+		if !errors.Is(err, api.ErrTransient) {
+			p.clientsetErr = err
+		}
+		return nil, err
+	}
+
+	p.clientset, p.contextNamespace = clientset, namespace
+	return clientset, nil
+}
+
+// clientsetError marks an error building the clientset, so that the fallback for a missing object does not hide it
+type clientsetError struct {
+	err error
+}
+
+func (e clientsetError) Error() string {
+	return e.err.Error()
+}
+
+func (e clientsetError) Unwrap() error {
+	return e.err
+}
+
+// Build the clientset along with the namespace of the kube context.
+// With kubeConfigFromSecret, the clientset built from the ambient config is used only to fetch the kubeconfig for the actual clientset.
+func (p *provider) buildClientset() (kubernetes.Interface, string, error) {
+	// Impersonation applies to the lookups, not to fetching the kubeconfig
+	impersonate := p.Impersonate
+	if p.KubeConfigFromSecret != "" {
+		impersonate = rest.ImpersonationConfig{}
+	}
+
+	var clientset kubernetes.Interface
+	var namespace string
+	var err error
+
+	if p.Server != "" {
+		config := p.serverConfig()
+		config.Impersonate = impersonate
+		clientset, err = newClientsetForConfig(config)
+		namespace = defaultNamespace
+	} else if p.KubeConfigContent != "" {
+		clientset, namespace, err = newClientsetFromKubeConfig([]byte(p.KubeConfigContent), p.KubeContext, impersonate)
+	} else {
+		clientset, err = newClientset(p.KubeConfigPath, p.KubeContext, p.InCluster, impersonate)
+		if err == nil {
+			namespace, err = getContextNamespace(p.KubeConfigPath, p.KubeContext, p.InCluster)
+		}
+	}
+
+	if err != nil || p.KubeConfigFromSecret == "" {
+		return clientset, namespace, err
+	}
+
+	return p.clientsetFromSecret(clientset)
+}
+
+// Build the clientset from the kubeconfig stored in the Secret referenced by the kubeConfigFromSecret URI parameter
+func (p *provider) clientsetFromSecret(clientset kubernetes.Interface) (kubernetes.Interface, string, error) {
+	namespace, name, key, _ := parseKubeConfigFromSecret(p.KubeConfigFromSecret)
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	var object map[string]string
+	err := retry.Do(ctx, p.Retries, isTransientError, func() error {
+		var err error
+		object, err = getObjectWithClientset(clientset, "Secret", namespace, name, "", ctx)
+		if err != nil && isTransientError(err) {
+			p.log.Debugf("vals-k8s: Transient error getting the kubeconfig from Secret %s/%s: %s", namespace, name, err)
+		}
+		return err
+	})
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, "", fmt.Errorf("Timed out after %s getting the kubeconfig from Secret %s/%s: %w", p.Timeout, namespace, name, api.WithKind(err, api.ErrTransient))
+		}
+		return nil, "", fmt.Errorf("Unable to get the kubeconfig from Secret %s/%s: %w", namespace, name, classifyError(err))
+	}
+	kubeconfig, ok := object[key]
+	if !ok {
+		return nil, "", api.WithKind(fmt.Errorf("Key %s does not exist in Secret %s/%s holding the kubeconfig", key, namespace, name), api.ErrNotFound)
+	}
+
+	p.log.Debugf("vals-k8s: Using the kubeconfig from key %s of Secret %s/%s", key, namespace, name)
+
+	clientset, contextNamespace, err := newClientsetFromKubeConfig([]byte(kubeconfig), "", p.Impersonate)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to use the kubeconfig in key %s of Secret %s/%s: %w", key, namespace, name, err)
+	}
+
+	return clientset, contextNamespace, nil
+}
+
+// Build the clientset from the kubeconfig content instead of a file, using the given context or the current one,
+// along with the namespace of the context
+func newClientsetFromKubeConfig(kubeconfig []byte, kubeContext string, impersonate rest.ImpersonationConfig) (kubernetes.Interface, string, error) {
+	raw, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to parse the kubeconfig: %s", err)
+	}
+
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*raw, kubeContext, &clientcmd.ConfigOverrides{
+		AuthInfo: clientcmdapi.AuthInfo{
+			Impersonate:       impersonate.UserName,
+			ImpersonateGroups: impersonate.Groups,
+		},
+	}, nil)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to build config from the kubeconfig: %s", err)
+	}
+
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", fmt.Errorf("Unable to get the namespace of the kube context: %s", err)
+	}
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	clientset, err := newClientsetForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return clientset, namespace, nil
+}
+
+// Split the kubeConfigFromSecret URI parameter into the namespace, name and key of the Secret holding the kubeconfig
+func parseKubeConfigFromSecret(v string) (string, string, string, error) {
+	splits := strings.Split(v, "/")
+	if len(splits) != 3 || splits[0] == "" || splits[1] == "" || splits[2] == "" {
+		return "", "", "", fmt.Errorf("Invalid kubeConfigFromSecret %s. It must be in the format namespace/secret/key.", v)
+	}
+	return splits[0], splits[1], splits[2], nil
+}
+
+// Return the namespace, or the namespace of the kube context if the path has none
+func (p *provider) resolveNamespace(namespace string, path string) (string, error) {
+	if namespace != "" {
+		return namespace, nil
+	}
+
+	if _, err := p.getClientset(); err != nil {
+		return "", fmt.Errorf("Unable to get the namespace for path %s: %w", path, err)
+	}
+
+	p.log.Debugf("vals-k8s: No namespace in path %s. Using namespace %s from the kube context.", path, p.contextNamespace)
+
+	return p.contextNamespace, nil
+}
+
+// Return the namespace of the kube context, the namespace of the pod when using the in-cluster config, or "default"
+func getContextNamespace(kubeConfigPath string, kubeContext string, inCluster bool) (string, error) {
+	if inCluster {
+		data, err := os.ReadFile(inClusterNamespaceFile)
+		if err != nil {
+			return defaultNamespace, nil
+		}
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace, nil
+		}
+		return defaultNamespace, nil
+	}
+
+	namespace, _, err := newClientConfig(kubeContext, kubeConfigPath, rest.ImpersonationConfig{}).Namespace()
+	if err != nil {
+		return "", fmt.Errorf("Unable to get the namespace of the kube context: %s", err)
+	}
+	if namespace == "" {
+		return defaultNamespace, nil
+	}
+
+	return namespace, nil
+}
+
+// Report whether the error is likely to go away on retry, like rate limiting or a dropped connection.
+// Errors like NotFound or Forbidden are permanent.
+// Whether the error is from reaching the cluster rather than from the cluster itself, so that another cluster may succeed.
+// Errors building the clientset, like a kube context missing from the kubeconfig, are too.
+func isConnectionError(err error) bool {
+	var ce clientsetError
+	var urlErr *url.Error
+	return errors.As(err, &ce) ||
+		errors.As(err, &urlErr) ||
+		errors.Is(err, api.ErrTransient) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		isTransientError(err)
+}
+
+func isTransientError(err error) bool {
+	return apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) ||
+		apierrors.IsServiceUnavailable(err) ||
+		utilnet.IsConnectionReset(err) ||
+		utilnet.IsConnectionRefused(err) ||
+		utilnet.IsProbableEOF(err)
 }
 
 // Return an empty Kube context if none is provided
@@ -129,50 +938,120 @@ func getKubeContext(cfg api.StaticConfig) string {
 	return ""
 }
 
-// Build the client-go config using a specific context
-func buildConfigWithContextFromFlags(context string, kubeconfigPath string) (*rest.Config, error) {
+// Build the client-go config using a specific context, impersonating the given user and groups if any
+func buildConfigWithContextFromFlags(context string, kubeconfigPath string, impersonate rest.ImpersonationConfig) (*rest.Config, error) {
+	return newClientConfig(context, kubeconfigPath, impersonate).ClientConfig()
+}
+
+// Load the kubeconfig lazily, selecting the specific context
+func newClientConfig(context string, kubeconfigPath string, impersonate rest.ImpersonationConfig) clientcmd.ClientConfig {
 	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
 		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
 		&clientcmd.ConfigOverrides{
 			CurrentContext: context,
-		}).ClientConfig()
+			AuthInfo: clientcmdapi.AuthInfo{
+				Impersonate:       impersonate.UserName,
+				ImpersonateGroups: impersonate.Groups,
+			},
+		})
 }
 
-// Fetch the object from the Kubernetes cluster
-func getObject(kind string, namespace string, name string, kubeConfigPath string, kubeContext string, inCluster bool, ctx context.Context) (map[string]string, error) {
+// Build the clientset from the in-cluster config or the kubeconfig
+func newClientset(kubeConfigPath string, kubeContext string, inCluster bool, impersonate rest.ImpersonationConfig) (kubernetes.Interface, error) {
 	var config *rest.Config
 	var err error
 
 	if inCluster {
 		config, err = rest.InClusterConfig()
+		if err == nil {
+			config.Impersonate = impersonate
+		}
 	} else {
-		config, err = buildConfigWithContextFromFlags(kubeContext, kubeConfigPath)
+		config, err = buildConfigWithContextFromFlags(kubeContext, kubeConfigPath, impersonate)
 	}
 
 	if err != nil {
 		return nil, fmt.Errorf("Unable to build config from vals configuration: %s", err)
 	}
 
+	return newClientsetForConfig(config)
+}
+
+// Build the config for connecting to the server with the bearer token, without any kubeconfig
+func (p *provider) serverConfig() *rest.Config {
+	return &rest.Config{
+		Host:        p.Server,
+		BearerToken: p.Token,
+		Impersonate: p.Impersonate,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAFile:   p.CACert,
+			Insecure: p.InsecureSkipTLSVerify,
+		},
+	}
+}
+
+// newClientsetForConfig is replaced in tests to count the clientsets built
+var newClientsetForConfig = func(config *rest.Config) (kubernetes.Interface, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to create the Kubernetes client: %s", err)
 	}
 
+	return clientset, nil
+}
+
+// Fetch the object using the given clientset.
+// When the name is "-", the single object matching the label selector is fetched instead.
+func getObjectWithClientset(clientset kubernetes.Interface, kind string, namespace string, name string, labelSelector string, ctx context.Context) (map[string]string, error) {
+	if err := validateName(kind, name, labelSelector); err != nil {
+		return nil, err
+	}
+
 	var object map[string]string
 
 	switch kind {
 	case "Secret":
+		if name == nameSelectedByLabels {
+			secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+			if err != nil {
+				return nil, fmt.Errorf("Unable to list the Secret objects from Kubernetes: %w", err)
+			}
+			names := make([]string, 0, len(secrets.Items))
+			for _, secret := range secrets.Items {
+				names = append(names, secret.Name)
+			}
+			i, err := selectOne(kind, namespace, labelSelector, names)
+			if err != nil {
+				return nil, err
+			}
+			return convertByteMapToStringMap(secrets.Items[i].Data), nil
+		}
 		secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
-			return nil, fmt.Errorf("Unable to get the Secret object from Kubernetes: %s", err)
+			return nil, fmt.Errorf("Unable to get the Secret object from Kubernetes: %w", err)
 		}
 		object = convertByteMapToStringMap(secret.Data)
 	case "ConfigMap":
+		if name == nameSelectedByLabels {
+			configmaps, err := clientset.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+			if err != nil {
+				return nil, fmt.Errorf("Unable to list the ConfigMap objects from Kubernetes: %w", err)
+			}
+			names := make([]string, 0, len(configmaps.Items))
+			for _, configmap := range configmaps.Items {
+				names = append(names, configmap.Name)
+			}
+			i, err := selectOne(kind, namespace, labelSelector, names)
+			if err != nil {
+				return nil, err
+			}
+			return convertConfigMapDataToStringMap(configmaps.Items[i].Data, configmaps.Items[i].BinaryData), nil
+		}
 		configmap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
 		if err != nil {
-			return nil, fmt.Errorf("Unable to get the ConfigMap object from Kubernetes: %s", err)
+			return nil, fmt.Errorf("Unable to get the ConfigMap object from Kubernetes: %w", err)
 		}
-		object = configmap.Data
+		object = convertConfigMapDataToStringMap(configmap.Data, configmap.BinaryData)
 	default:
 		return nil, fmt.Errorf("The specified kind is not valid. Valid kinds: Secret, ConfigMap")
 	}
@@ -180,6 +1059,57 @@ func getObject(kind string, namespace string, name string, kubeConfigPath string
 	return object, nil
 }
 
+// Check that the label selector is set when the name is "-"
+func validateName(kind string, name string, labelSelector string) error {
+	if name == nameSelectedByLabels && labelSelector == "" {
+		return fmt.Errorf("The name %s requires the labelSelector URI parameter to select the %s", nameSelectedByLabels, kind)
+	}
+	return nil
+}
+
+// HealthCheck verifies that the Kubernetes API server can be reached with the configured kubeconfig, context and credentials,
+// by getting the version of the server
+func (p *provider) HealthCheck(ctx context.Context) error {
+	if len(p.contexts) > 0 {
+		return p.failover("the version of the Kubernetes API server", func(sub *provider) error {
+			return sub.HealthCheck(ctx)
+		})
+	}
+
+	clientset, err := p.getClientset()
+	if err != nil {
+		return fmt.Errorf("Unable to check the health of the Kubernetes API server: %w", err)
+	}
+
+	// The same request as ServerVersion, but canceled along with ctx
+	if err := clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("Unable to get the version of the Kubernetes API server: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+// Close stops the watches started with the watch URI parameter, if any
+func (p *provider) Close() error {
+	p.watchers.stop()
+	for _, sub := range p.contexts {
+		_ = sub.Close()
+	}
+	return nil
+}
+
+// Return the index of the only object matching the label selector
+func selectOne(kind string, namespace string, labelSelector string, names []string) (int, error) {
+	switch len(names) {
+	case 0:
+		return 0, api.WithKind(fmt.Errorf("No %s matches the label selector %s in namespace %s", kind, labelSelector, namespace), api.ErrNotFound)
+	case 1:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%d objects of kind %s match the label selector %s in namespace %s: %s. The label selector must match exactly one", len(names), kind, labelSelector, namespace, strings.Join(names, ", "))
+	}
+}
+
 func convertByteMapToStringMap(byteMap map[string][]byte) map[string]string {
 	stringMap := make(map[string]string)
 
@@ -189,3 +1119,14 @@ func convertByteMapToStringMap(byteMap map[string][]byte) map[string]string {
 
 	return stringMap
 }
+
+// Merge the ConfigMap's Data and BinaryData, preferring Data when a key exists in both
+func convertConfigMapDataToStringMap(data map[string]string, binaryData map[string][]byte) map[string]string {
+	stringMap := convertByteMapToStringMap(binaryData)
+
+	for key, value := range data {
+		stringMap[key] = value
+	}
+
+	return stringMap
+}
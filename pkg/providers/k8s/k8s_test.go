@@ -2,15 +2,33 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
+	"github.com/helmfile/vals/pkg/api"
 	"github.com/helmfile/vals/pkg/config"
 	"github.com/helmfile/vals/pkg/log"
+	"github.com/helmfile/vals/pkg/mask"
 )
 
 // Setup:
@@ -120,7 +138,11 @@ func Test_getObject(t *testing.T) {
 	for i := range testcases {
 		tc := testcases[i]
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			got, err := getObject(tc.kind, tc.namespace, tc.name, tc.kubeConfigPath, "", false, context.Background())
+			var got map[string]string
+			clientset, err := newClientset(tc.kubeConfigPath, "", false, rest.ImpersonationConfig{})
+			if err == nil {
+				got, err = getObjectWithClientset(clientset, tc.kind, tc.namespace, tc.name, "", context.Background())
+			}
 			if err != nil {
 				if err.Error() != tc.wantErr {
 					t.Fatalf("unexpected error: want %q, got %q", tc.wantErr, err.Error())
@@ -162,7 +184,11 @@ func Test_getObject_InCluster(t *testing.T) {
 	for i := range testcases {
 		tc := testcases[i]
 		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
-			got, err := getObject(tc.kind, tc.namespace, tc.name, "", "", true, context.Background())
+			var got map[string]string
+			clientset, err := newClientset("", "", true, rest.ImpersonationConfig{})
+			if err == nil {
+				got, err = getObjectWithClientset(clientset, tc.kind, tc.namespace, tc.name, "", context.Background())
+			}
 			if err != nil {
 				if err.Error() != tc.wantErr {
 					t.Fatalf("unexpected error: want %q, got %q", tc.wantErr, err.Error())
@@ -317,13 +343,13 @@ func Test_GetString(t *testing.T) {
 		{
 			path:    "v1/Secret/test-namespace/mysecret/key/more/path",
 			want:    "",
-			wantErr: "Invalid path v1/Secret/test-namespace/mysecret/key/more/path. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+			wantErr: "Invalid path v1/Secret/test-namespace/mysecret/key/more/path. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>, but it has 7 segments. Keys cannot contain slashes, so a key like a/b cannot be referenced.",
 		},
 		// (configmap) Invalid path is specified
 		{
 			path:    "v1/ConfigMap/test-namespace/myconfigmap/key/more/path",
 			want:    "",
-			wantErr: "Invalid path v1/ConfigMap/test-namespace/myconfigmap/key/more/path. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+			wantErr: "Invalid path v1/ConfigMap/test-namespace/myconfigmap/key/more/path. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>, but it has 7 segments. Keys cannot contain slashes, so a key like a/b cannot be referenced.",
 		},
 		// (secret) Non-existent namespace is specified
 		{
@@ -365,7 +391,7 @@ func Test_GetString(t *testing.T) {
 		{
 			path:    "bad/data/path",
 			want:    "",
-			wantErr: "Invalid path bad/data/path. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+			wantErr: "Invalid path bad/data/path. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>, or the same without <namespace> to use the namespace of the kube context, but it has only 3 segments.",
 		},
 		// Unsupported kind is specified
 		{
@@ -401,3 +427,1621 @@ func Test_GetString(t *testing.T) {
 		})
 	}
 }
+
+func Test_GetStringMap(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	tests := []struct {
+		want    map[string]interface{}
+		path    string
+		wantErr string
+	}{
+		// (secret) Valid path is specified
+		{
+			path:    "v1/Secret/test-namespace/mysecret",
+			want:    map[string]interface{}{"key": "p4ssw0rd"},
+			wantErr: "",
+		},
+		// (configmap) Valid path is specified
+		{
+			path:    "v1/ConfigMap/test-namespace/myconfigmap",
+			want:    map[string]interface{}{"key": "configValue"},
+			wantErr: "",
+		},
+		// Incorrect path is specified
+		{
+			path:    "v1/Secret",
+			want:    nil,
+			wantErr: "Invalid path v1/Secret. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>, or the same without <namespace> to use the namespace of the kube context, but it has only 2 segments.",
+		},
+		// (secret) Invalid apiVersion specified
+		{
+			path:    "v2/Secret/test-namespace/mysecret",
+			want:    nil,
+			wantErr: "Invalid apiVersion v2. Only apiVersion v1 is supported at this time.",
+		},
+		// (secret) Non-existent secret is specified
+		{
+			path:    "v1/Secret/test-namespace/badsecret",
+			want:    nil,
+			wantErr: "Unable to get Secret test-namespace/badsecret: Unable to get the Secret object from Kubernetes: secrets \"badsecret\" not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			homeDir, _ := os.UserHomeDir()
+			conf := map[string]interface{}{}
+			conf["kubeConfigPath"] = fmt.Sprintf("%s/.kube/config", homeDir)
+			conf["kubeContext"] = "kind-cluster"
+			p, err := New(logger, config.MapConfig{M: conf})
+			require.NoErrorf(t, err, "unexpected error creating provider: %v", err)
+
+			got, err := p.GetStringMap(tc.path)
+			if err != nil {
+				if err.Error() != tc.wantErr {
+					t.Fatalf("unexpected error: want %q, got %q", tc.wantErr, err.Error())
+				}
+			} else {
+				if tc.wantErr != "" {
+					t.Fatalf("expected error did not occur: want %q, got none", tc.wantErr)
+				}
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected result: -(want), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_convertConfigMapDataToStringMap(t *testing.T) {
+	testcases := []struct {
+		data       map[string]string
+		binaryData map[string][]byte
+		want       map[string]string
+	}{
+		// Only Data is set
+		{
+			data: map[string]string{"key": "configValue"},
+			want: map[string]string{"key": "configValue"},
+		},
+		// Only BinaryData is set
+		{
+			binaryData: map[string][]byte{"bin": []byte("binaryValue")},
+			want:       map[string]string{"bin": "binaryValue"},
+		},
+		// Data takes precedence over BinaryData
+		{
+			data:       map[string]string{"key": "configValue"},
+			binaryData: map[string][]byte{"key": []byte("binaryValue"), "bin": []byte("binaryValue")},
+			want:       map[string]string{"key": "configValue", "bin": "binaryValue"},
+		},
+		// Neither is set
+		{
+			want: map[string]string{},
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			got := convertConfigMapDataToStringMap(tc.data, tc.binaryData)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected result: -(want), +(got)\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_New_InClusterFallback(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	testcases := []struct {
+		config        map[string]interface{}
+		serviceHost   string
+		wantInCluster bool
+		wantErr       string
+	}{
+		// No kubeconfig is found but running inside a cluster
+		{
+			config:        map[string]interface{}{},
+			serviceHost:   "10.0.0.1",
+			wantInCluster: true,
+		},
+		// No kubeconfig is found but running inside a cluster, kubeContext is ignored
+		{
+			config:        map[string]interface{}{"kubeContext": "minikube"},
+			serviceHost:   "10.0.0.1",
+			wantInCluster: true,
+		},
+		// No kubeconfig is found and not running inside a cluster
+		{
+			config:      map[string]interface{}{},
+			serviceHost: "",
+			wantErr:     "No path was found in any of the following: kubeContext URI param, KUBECONFIG environment variable, or default path",
+		},
+		// kubeConfigPath is set explicitly, so no fallback happens
+		{
+			config:      map[string]interface{}{"kubeConfigPath": "/tmp/does-not-exist"},
+			serviceHost: "10.0.0.1",
+			wantErr:     "kubeConfigPath URI parameter is set but path /tmp/does-not-exist does not exist.",
+		},
+	}
+
+	for i := range testcases {
+		tc := testcases[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv("KUBECONFIG", "")
+			t.Setenv("KUBERNETES_SERVICE_HOST", tc.serviceHost)
+			t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+			p, err := New(logger, config.MapConfig{M: tc.config})
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.wantInCluster, p.InCluster)
+			require.Equal(t, "", p.KubeContext)
+		})
+	}
+}
+
+// Write a kubeconfig pointing at the given API server and return its path
+func writeKubeConfig(t *testing.T, server string) string {
+	t.Helper()
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test-cluster
+  cluster:
+    server: %s
+contexts:
+- name: test-context
+  context:
+    cluster: test-cluster
+    user: test-user
+current-context: test-context
+users:
+- name: test-user
+  user:
+    token: test-token
+`, server)
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0o600))
+	return path
+}
+
+func Test_New_Timeout(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	kubeConfigPath := writeKubeConfig(t, "https://127.0.0.1:6443")
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, p.Timeout)
+
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "timeout": "5s"}})
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Second, p.Timeout)
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "timeout": "five"}})
+	require.EqualError(t, err, `Invalid timeout five: time: invalid duration "five"`)
+}
+
+func Test_GetString_Timeout(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+
+	// An API server that never responds in time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	conf := map[string]interface{}{
+		"kubeConfigPath": writeKubeConfig(t, server.URL),
+		"timeout":        "100ms",
+	}
+	p, err := New(logger, config.MapConfig{M: conf})
+	require.NoError(t, err)
+
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorContains(t, err, "Timed out after 100ms getting Secret test-namespace/mysecret")
+}
+
+// Start an API server serving the given Secrets and ConfigMaps, responding NotFound to anything else
+func newFakeAPIServer(t *testing.T, secrets []corev1.Secret, configMaps []corev1.ConfigMap) *httptest.Server {
+	t.Helper()
+	objects := map[string]interface{}{}
+	for i := range secrets {
+		secret := secrets[i]
+		secret.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"}
+		objects[fmt.Sprintf("/api/v1/namespaces/%s/secrets/%s", secret.Namespace, secret.Name)] = secret
+	}
+	for i := range configMaps {
+		configMap := configMaps[i]
+		configMap.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+		objects[fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", configMap.Namespace, configMap.Name)] = configMap
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		require.NoError(t, err)
+		fieldSelector, err := fields.ParseSelector(r.URL.Query().Get("fieldSelector"))
+		require.NoError(t, err)
+		if r.URL.Query().Get("watch") == "true" {
+			// A watch that never sees any change
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		if r.URL.Path == "/version" {
+			_ = json.NewEncoder(w).Encode(map[string]string{"major": "1", "minor": "32", "gitVersion": "v1.32.2"})
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/secrets") {
+			list := corev1.SecretList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "SecretList"}}
+			for _, secret := range secrets {
+				if r.URL.Path == fmt.Sprintf("/api/v1/namespaces/%s/secrets", secret.Namespace) && selector.Matches(labels.Set(secret.Labels)) && fieldSelector.Matches(fields.Set{"metadata.name": secret.Name}) {
+					list.Items = append(list.Items, secret)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(list)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/configmaps") {
+			list := corev1.ConfigMapList{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMapList"}}
+			for _, configMap := range configMaps {
+				if r.URL.Path == fmt.Sprintf("/api/v1/namespaces/%s/configmaps", configMap.Namespace) && selector.Matches(labels.Set(configMap.Labels)) && fieldSelector.Matches(fields.Set{"metadata.name": configMap.Name}) {
+					list.Items = append(list.Items, configMap)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(list)
+			return
+		}
+		obj, ok := objects[r.URL.Path]
+		if !ok {
+			segments := strings.Split(r.URL.Path, "/")
+			status := apierrors.NewNotFound(schema.GroupResource{Resource: segments[len(segments)-2]}, segments[len(segments)-1]).ErrStatus
+			status.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}
+			w.WriteHeader(http.StatusNotFound)
+			obj = status
+		}
+		_ = json.NewEncoder(w).Encode(obj)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_GetString_Fallback(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	tests := []struct {
+		config  map[string]interface{}
+		path    string
+		want    string
+		wantErr string
+	}{
+		// Existing key, the fallback is not used
+		{
+			config: map[string]interface{}{"fallback_value": "default"},
+			path:   "v1/Secret/test-namespace/mysecret/key",
+			want:   "p4ssw0rd",
+		},
+		// Non-existent key with fallback_value
+		{
+			config: map[string]interface{}{"fallback_value": "default"},
+			path:   "v1/Secret/test-namespace/mysecret/non-existent-key",
+			want:   "default",
+		},
+		// Non-existent secret with fallback_value
+		{
+			config: map[string]interface{}{"fallback_value": "default"},
+			path:   "v1/Secret/test-namespace/non-existent-secret/key",
+			want:   "default",
+		},
+		// Non-existent key with optional
+		{
+			config: map[string]interface{}{"optional": "true"},
+			path:   "v1/Secret/test-namespace/mysecret/non-existent-key",
+			want:   "",
+		},
+		// Non-existent secret with optional
+		{
+			config: map[string]interface{}{"optional": "true"},
+			path:   "v1/Secret/test-namespace/non-existent-secret/key",
+			want:   "",
+		},
+		// Non-existent key without optional nor fallback_value
+		{
+			config:  map[string]interface{}{},
+			path:    "v1/Secret/test-namespace/mysecret/non-existent-key",
+			wantErr: "Key non-existent-key does not exist in test-namespace/mysecret",
+		},
+		// Non-existent secret without optional nor fallback_value
+		{
+			config:  map[string]interface{}{},
+			path:    "v1/Secret/test-namespace/non-existent-secret/key",
+			wantErr: "Unable to get Secret test-namespace/non-existent-secret: Unable to get the Secret object from Kubernetes: secrets \"non-existent-secret\" not found",
+		},
+		// No secret matching the label selector with fallback_value
+		{
+			config: map[string]interface{}{"fallback_value": "default", "labelSelector": "app=none"},
+			path:   "v1/Secret/test-namespace/-/key",
+			want:   "default",
+		},
+		// No secret matching the label selector with optional
+		{
+			config: map[string]interface{}{"optional": "true", "labelSelector": "app=none"},
+			path:   "v1/Secret/test-namespace/-/key",
+			want:   "",
+		},
+		// A missing kubeconfig Secret is not hidden by fallback_value
+		{
+			config:  map[string]interface{}{"fallback_value": "default", "kubeConfigFromSecret": "test-namespace/mysecret/non-existent-key"},
+			path:    "v1/Secret/test-namespace/mysecret/key",
+			wantErr: "Unable to get Secret test-namespace/mysecret: Key non-existent-key does not exist in Secret test-namespace/mysecret holding the kubeconfig",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			tc.config["kubeConfigPath"] = kubeConfigPath
+			p, err := New(logger, config.MapConfig{M: tc.config})
+			require.NoError(t, err)
+
+			got, err := p.GetString(tc.path)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_GetString_Retries(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	backend := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+
+	tests := []struct {
+		config       map[string]interface{}
+		path         string
+		failures     int
+		want         string
+		wantErr      string
+		wantRequests int
+	}{
+		// Succeeds after transient errors
+		{
+			config:       map[string]interface{}{},
+			path:         "v1/Secret/test-namespace/mysecret/key",
+			failures:     2,
+			want:         "p4ssw0rd",
+			wantRequests: 3,
+		},
+		// Gives up after the configured number of retries
+		{
+			config:       map[string]interface{}{"retries": "1"},
+			path:         "v1/Secret/test-namespace/mysecret/key",
+			failures:     2,
+			wantErr:      "Unable to get Secret test-namespace/mysecret: Unable to get the Secret object from Kubernetes: the server is currently unable to handle the request (get secrets mysecret)",
+			wantRequests: 2,
+		},
+		// NotFound is never retried
+		{
+			config:       map[string]interface{}{},
+			path:         "v1/Secret/test-namespace/non-existent-secret/key",
+			wantErr:      "Unable to get Secret test-namespace/non-existent-secret: Unable to get the Secret object from Kubernetes: secrets \"non-existent-secret\" not found",
+			wantRequests: 1,
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tc.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				backend.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			tc.config["kubeConfigPath"] = writeKubeConfig(t, server.URL)
+			p, err := New(logger, config.MapConfig{M: tc.config})
+			require.NoError(t, err)
+
+			got, err := p.GetString(tc.path)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.want, got)
+			}
+			require.Equal(t, tc.wantRequests, requests)
+		})
+	}
+}
+
+func Test_GetString_LabelSelector(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "payments-v1", Labels: map[string]string{"app": "payments", "active": "false"}},
+			Data:       map[string][]byte{"key": []byte("old")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "payments-v2", Labels: map[string]string{"app": "payments", "active": "true"}},
+			Data:       map[string][]byte{"key": []byte("new")},
+		},
+	}, []corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "flags", Labels: map[string]string{"app": "payments"}},
+			Data:       map[string]string{"key": "configValue"},
+		},
+	})
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	tests := []struct {
+		labelSelector string
+		path          string
+		want          string
+		wantErr       string
+	}{
+		// (secret) Exactly one Secret matches
+		{
+			labelSelector: "app=payments,active=true",
+			path:          "v1/Secret/test-namespace/-/key",
+			want:          "new",
+		},
+		// (configmap) Exactly one ConfigMap matches
+		{
+			labelSelector: "app=payments",
+			path:          "v1/ConfigMap/test-namespace/-/key",
+			want:          "configValue",
+		},
+		// (secret) More than one Secret matches
+		{
+			labelSelector: "app=payments",
+			path:          "v1/Secret/test-namespace/-/key",
+			wantErr:       "Unable to get Secret test-namespace/-: 2 objects of kind Secret match the label selector app=payments in namespace test-namespace: payments-v1, payments-v2. The label selector must match exactly one",
+		},
+		// (secret) No Secret matches
+		{
+			labelSelector: "app=billing",
+			path:          "v1/Secret/test-namespace/-/key",
+			wantErr:       "Unable to get Secret test-namespace/-: No Secret matches the label selector app=billing in namespace test-namespace",
+		},
+		// (secret) No label selector is specified
+		{
+			path:    "v1/Secret/test-namespace/-/key",
+			wantErr: "Unable to get Secret test-namespace/-: The name - requires the labelSelector URI parameter to select the Secret",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			conf := map[string]interface{}{"kubeConfigPath": kubeConfigPath}
+			if tc.labelSelector != "" {
+				conf["labelSelector"] = tc.labelSelector
+			}
+			p, err := New(logger, config.MapConfig{M: conf})
+			require.NoError(t, err)
+
+			got, err := p.GetString(tc.path)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_New_Impersonation(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	kubeConfigPath := writeKubeConfig(t, "https://127.0.0.1:6443")
+
+	tests := []struct {
+		config  map[string]interface{}
+		want    rest.ImpersonationConfig
+		wantErr string
+	}{
+		// No impersonation
+		{
+			config: map[string]interface{}{},
+		},
+		// User and groups
+		{
+			config: map[string]interface{}{"impersonateUser": "jane", "impersonateGroups": "auditors, break-glass"},
+			want:   rest.ImpersonationConfig{UserName: "jane", Groups: []string{"auditors", "break-glass"}},
+		},
+		// Service account
+		{
+			config: map[string]interface{}{"impersonateServiceAccount": "ops:reader"},
+			want:   rest.ImpersonationConfig{UserName: "system:serviceaccount:ops:reader"},
+		},
+		// Invalid service account
+		{
+			config:  map[string]interface{}{"impersonateServiceAccount": "reader"},
+			wantErr: "Invalid impersonateServiceAccount reader. impersonateServiceAccount must be in the format <namespace>:<name>",
+		},
+		// User and service account
+		{
+			config:  map[string]interface{}{"impersonateUser": "jane", "impersonateServiceAccount": "ops:reader"},
+			wantErr: "impersonateUser and impersonateServiceAccount URI parameters are mutually exclusive.",
+		},
+		// Groups without a user
+		{
+			config:  map[string]interface{}{"impersonateGroups": "auditors"},
+			wantErr: "impersonateGroups URI parameter requires either impersonateUser or impersonateServiceAccount to be set.",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			tc.config["kubeConfigPath"] = kubeConfigPath
+			p, err := New(logger, config.MapConfig{M: tc.config})
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, p.Impersonate)
+		})
+	}
+}
+
+func Test_GetString_Impersonation(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	fake := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	conf := map[string]interface{}{
+		"kubeConfigPath":    writeKubeConfig(t, server.URL),
+		"impersonateUser":   "jane",
+		"impersonateGroups": "auditors,break-glass",
+	}
+	p, err := New(logger, config.MapConfig{M: conf})
+	require.NoError(t, err)
+
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+	require.Equal(t, "jane", header.Get("Impersonate-User"))
+	require.Equal(t, []string{"auditors", "break-glass"}, header.Values("Impersonate-Group"))
+}
+
+func Test_GetString_Encode(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	binary := []byte{0xff, 0xfe, 0x00, 0x01}
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "keystore"},
+			Data:       map[string][]byte{"keystore.jks": binary},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "encode": "base64"}})
+	require.NoError(t, err)
+
+	got, err := p.GetString("v1/Secret/test-namespace/keystore/keystore.jks")
+	require.NoError(t, err)
+	require.Equal(t, "//4AAQ==", got)
+
+	gotMap, err := p.GetStringMap("v1/Secret/test-namespace/keystore")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"keystore.jks": "//4AAQ=="}, gotMap)
+
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+
+	got, err = p.GetString("v1/Secret/test-namespace/keystore/keystore.jks")
+	require.NoError(t, err)
+	require.Equal(t, string(binary), got)
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "encode": "hex"}})
+	require.EqualError(t, err, "Unsupported encode parameter: 'hex'.")
+}
+
+func Test_GetString_ReusesClientset(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+
+	// The kubeconfig is not read again once the clientset is built
+	require.NoError(t, os.Remove(kubeConfigPath))
+
+	got, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+}
+
+func Test_parsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		withKey bool
+		want    objectPath
+		wantErr string
+	}{
+		{
+			path:    "v1/Secret/ns/name/key",
+			withKey: true,
+			want:    objectPath{apiVersion: "v1", kind: "Secret", namespace: "ns", name: "name", key: "key"},
+		},
+		{
+			path:    "v1/Secret/name/key",
+			withKey: true,
+			want:    objectPath{apiVersion: "v1", kind: "Secret", name: "name", key: "key"},
+		},
+		{
+			path: "v1/ConfigMap/ns/name",
+			want: objectPath{apiVersion: "v1", kind: "ConfigMap", namespace: "ns", name: "name"},
+		},
+		{
+			path: "v1/ConfigMap/name",
+			want: objectPath{apiVersion: "v1", kind: "ConfigMap", name: "name"},
+		},
+		{
+			path:    "v1/Secret//name/key",
+			withKey: true,
+			wantErr: "Invalid path v1/Secret//name/key. The namespace is empty. Omit it along with its slash to use the namespace of the kube context. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+		},
+		{
+			path:    "v1/Secret/ns//key",
+			withKey: true,
+			wantErr: "Invalid path v1/Secret/ns//key. The name is empty. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+		},
+		{
+			path:    "v1/Secret/ns/name/",
+			withKey: true,
+			wantErr: "Invalid path v1/Secret/ns/name/. The key is empty. Remove the trailing slash when referencing the whole object with a fragment. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+		},
+		{
+			path:    "v1/Secret///",
+			withKey: true,
+			wantErr: "Invalid path v1/Secret///. The namespace is empty. Omit it along with its slash to use the namespace of the kube context. The name is empty. The key is empty. Remove the trailing slash when referencing the whole object with a fragment. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>",
+		},
+		{
+			path:    "v1/Secret/ns/name/tls/crt",
+			withKey: true,
+			wantErr: "Invalid path v1/Secret/ns/name/tls/crt. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>, but it has 6 segments. Keys cannot contain slashes, so a key like a/b cannot be referenced.",
+		},
+		{
+			path:    "v1/Secret/key",
+			withKey: true,
+			wantErr: "Invalid path v1/Secret/key. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>/<key>, or the same without <namespace> to use the namespace of the kube context, but it has only 3 segments.",
+		},
+		{
+			path:    "v2/Secret/ns/name/key",
+			withKey: true,
+			wantErr: "Invalid apiVersion v2. Only apiVersion v1 is supported at this time.",
+		},
+		{
+			path:    "v1/ConfigMap/ns/name/",
+			wantErr: "Invalid path v1/ConfigMap/ns/name/. The path must not end with a slash. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>",
+		},
+		{
+			path:    "v1/ConfigMap/ns/name/key/more",
+			wantErr: "Invalid path v1/ConfigMap/ns/name/key/more. Path must be in the format <apiVersion>/<kind>/<namespace>/<name>, but it has 6 segments.",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := parsePath(tc.path, tc.withKey)
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_GetString_Concurrent(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	var built int
+	var m sync.Mutex
+	orig := newClientsetForConfig
+	newClientsetForConfig = func(config *rest.Config) (kubernetes.Interface, error) {
+		m.Lock()
+		built++
+		m.Unlock()
+		return orig(config)
+	}
+	t.Cleanup(func() { newClientsetForConfig = orig })
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := "v1/Secret/test-namespace/mysecret/key"
+			if i%2 == 0 {
+				// Omitting the namespace reads the namespace of the kube context resolved along with the clientset
+				path = "v1/Secret/mysecret/key"
+			}
+			got, err := p.GetString(path)
+			if err == nil && got != "p4ssw0rd" {
+				err = fmt.Errorf("unexpected value %q", got)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, built)
+}
+
+func Test_GetString_ClientsetError(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	kubeConfigPath := writeKubeConfig(t, "https://127.0.0.1:6443")
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContext": "does-not-exist"}})
+	require.NoError(t, err)
+
+	// The error building the clientset is surfaced on every lookup, not only the first one
+	for i := 0; i < 2; i++ {
+		_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+		require.ErrorContains(t, err, `Unable to get Secret test-namespace/mysecret: Unable to build config from vals configuration: context "does-not-exist" does not exist`)
+	}
+}
+
+func Test_GetString_ContextNamespace(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromDefault")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "context-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromContext")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromPath")},
+		},
+	}, nil)
+
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	// A kubeconfig whose context sets the namespace
+	kubeConfigWithNamespacePath := filepath.Join(t.TempDir(), "config")
+	kubeconfig, err := os.ReadFile(kubeConfigPath)
+	require.NoError(t, err)
+	kubeconfig = []byte(strings.Replace(string(kubeconfig), "    user: test-user\n", "    user: test-user\n    namespace: context-namespace\n", 1))
+	require.NoError(t, os.WriteFile(kubeConfigWithNamespacePath, kubeconfig, 0o600))
+
+	tests := []struct {
+		kubeConfigPath string
+		path           string
+		want           string
+		wantMap        map[string]interface{}
+	}{
+		// The namespace in the path takes precedence
+		{
+			kubeConfigPath: kubeConfigWithNamespacePath,
+			path:           "v1/Secret/test-namespace/mysecret/key",
+			want:           "fromPath",
+		},
+		// The namespace of the kube context
+		{
+			kubeConfigPath: kubeConfigWithNamespacePath,
+			path:           "v1/Secret/mysecret/key",
+			want:           "fromContext",
+		},
+		// The default namespace when the kube context has none
+		{
+			kubeConfigPath: kubeConfigPath,
+			path:           "v1/Secret/mysecret/key",
+			want:           "fromDefault",
+		},
+		// A map from the namespace of the kube context
+		{
+			kubeConfigPath: kubeConfigWithNamespacePath,
+			path:           "v1/Secret/mysecret",
+			wantMap:        map[string]interface{}{"key": "fromContext"},
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": tc.kubeConfigPath}})
+			require.NoError(t, err)
+
+			if tc.wantMap != nil {
+				got, err := p.GetStringMap(tc.path)
+				require.NoError(t, err)
+				require.Equal(t, tc.wantMap, got)
+				return
+			}
+
+			got, err := p.GetString(tc.path)
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func Test_GetString_ErrorKinds(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	fake := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status *apierrors.StatusError
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/forbidden"):
+			status = apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "forbidden", fmt.Errorf("not allowed"))
+		case strings.HasSuffix(r.URL.Path, "/unavailable"):
+			status = apierrors.NewServiceUnavailable("try again later")
+		default:
+			fake.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		status.ErrStatus.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(int(status.ErrStatus.Code))
+		_ = json.NewEncoder(w).Encode(status.ErrStatus)
+	}))
+	defer server.Close()
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, server.URL), "retries": "0"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path string
+		want error
+	}{
+		{path: "v1/Secret/test-namespace/non-existent-secret/key", want: api.ErrNotFound},
+		{path: "v1/Secret/test-namespace/mysecret/non-existent-key", want: api.ErrNotFound},
+		{path: "v1/Secret/test-namespace/forbidden/key", want: api.ErrPermission},
+		{path: "v1/Secret/test-namespace/unavailable/key", want: api.ErrTransient},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			_, err := p.GetString(tc.path)
+			require.ErrorIs(t, err, tc.want)
+		})
+	}
+}
+
+func Test_New_Server(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	caCert := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caCert, []byte("not used"), 0o600))
+
+	tests := []struct {
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{config: map[string]interface{}{"server": "https://127.0.0.1:6443", "token": "test-token"}},
+		{config: map[string]interface{}{"server": "https://127.0.0.1:6443", "token": "test-token", "caCert": caCert}},
+		{config: map[string]interface{}{"server": "https://127.0.0.1:6443", "token": "test-token", "insecureSkipTLSVerify": "true"}},
+		{
+			config:  map[string]interface{}{"server": "https://127.0.0.1:6443"},
+			wantErr: "server and token URI parameters must be set together.",
+		},
+		{
+			config:  map[string]interface{}{"token": "test-token"},
+			wantErr: "server and token URI parameters must be set together.",
+		},
+		{
+			config:  map[string]interface{}{"server": "https://127.0.0.1:6443", "token": "test-token", "inCluster": ""},
+			wantErr: "server and inCluster URI parameters are mutually exclusive.",
+		},
+		{
+			config:  map[string]interface{}{"server": "https://127.0.0.1:6443", "token": "test-token", "caCert": caCert, "insecureSkipTLSVerify": "true"},
+			wantErr: "caCert and insecureSkipTLSVerify URI parameters are mutually exclusive.",
+		},
+		{
+			config:  map[string]interface{}{"server": "https://127.0.0.1:6443", "token": "test-token", "caCert": "/tmp/does-not-exist"},
+			wantErr: "caCert URI parameter is set but path /tmp/does-not-exist does not exist.",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			// No kubeconfig is needed
+			t.Setenv("KUBECONFIG", "/tmp/does-not-exist")
+			_, err := New(logger, config.MapConfig{M: tc.config})
+			if tc.wantErr != "" {
+				require.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func Test_GetString_Server(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	fake := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromDefault")},
+		},
+	}, nil)
+
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	t.Setenv("KUBECONFIG", "/tmp/does-not-exist")
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"server": server.URL, "token": "ci-token", "insecureSkipTLSVerify": "true"}})
+	require.NoError(t, err)
+
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+	require.Equal(t, "Bearer ci-token", authorization)
+
+	got, err = p.GetString("v1/Secret/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "fromDefault", got)
+}
+
+func Test_GetStringMap_Document(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data: map[string][]byte{
+				"config.yaml": []byte("db:\n  password: p4ssw0rd\n"),
+				"config.json": []byte(`{"db": {"password": "p4ssw0rd"}}`),
+				"password":    []byte("p4ssw0rd"),
+			},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	tests := []struct {
+		path    string
+		config  map[string]interface{}
+		want    map[string]interface{}
+		wantErr string
+	}{
+		{
+			path: "v1/Secret/test-namespace/mysecret/config.yaml",
+			want: map[string]interface{}{"db": map[string]interface{}{"password": "p4ssw0rd"}},
+		},
+		{
+			path: "v1/Secret/test-namespace/mysecret/config.json",
+			want: map[string]interface{}{"db": map[string]interface{}{"password": "p4ssw0rd"}},
+		},
+		{
+			path:    "v1/Secret/test-namespace/mysecret/password",
+			wantErr: "Unable to parse key password of Secret test-namespace/mysecret as YAML or JSON: ",
+		},
+		{
+			path:    "v1/Secret/test-namespace/mysecret/missing",
+			wantErr: "Key missing does not exist in test-namespace/mysecret",
+		},
+		{
+			path:   "v1/Secret/test-namespace/mysecret/missing",
+			config: map[string]interface{}{"fallback_value": "db: {password: fallback}"},
+			want:   map[string]interface{}{"db": map[string]interface{}{"password": "fallback"}},
+		},
+		{
+			path:   "v1/Secret/test-namespace/missing/config.yaml",
+			config: map[string]interface{}{"optional": "true"},
+			want:   map[string]interface{}{},
+		},
+		{
+			path:    "v1/Secret/test-namespace/mysecret/config.yaml",
+			config:  map[string]interface{}{"encode": "base64"},
+			wantErr: "encode=base64 cannot be used with a fragment into key config.yaml, whose contents are parsed as YAML or JSON.",
+		},
+		// A path to a key without the namespace reads as a Secret named after the key
+		{
+			path:    "v1/Secret/mysecret/config.yaml",
+			wantErr: "Unable to get Secret mysecret/config.yaml: Unable to get the Secret object from Kubernetes: secrets \"config.yaml\" not found. If mysecret is the name of the Secret and config.yaml is a key, include the namespace like v1/Secret/<namespace>/mysecret/config.yaml to reference a field within the key.",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			conf := map[string]interface{}{"kubeConfigPath": kubeConfigPath}
+			for k, v := range tc.config {
+				conf[k] = v
+			}
+			p, err := New(logger, config.MapConfig{M: conf})
+			require.NoError(t, err)
+
+			got, err := p.GetStringMap(tc.path)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+
+	// The plain key read stays as is
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/config.yaml")
+	require.NoError(t, err)
+	require.Equal(t, "db:\n  password: p4ssw0rd\n", got)
+}
+
+func Test_GetString_Mask(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data: map[string][]byte{
+				"key":         []byte("k8s-string-s3cret"),
+				"other":       []byte("k8s-map-s3cret"),
+				"config.yaml": []byte("db:\n  password: k8s-document-s3cret\n"),
+			},
+		},
+	}, []corev1.ConfigMap{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "myconfigmap"},
+			Data:       map[string]string{"key": "k8s-config-value"},
+		},
+	})
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, server.URL)}})
+	require.NoError(t, err)
+
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	_, err = p.GetStringMap("v1/Secret/test-namespace/mysecret")
+	require.NoError(t, err)
+	_, err = p.GetStringMap("v1/Secret/test-namespace/mysecret/config.yaml")
+	require.NoError(t, err)
+	_, err = p.GetString("v1/ConfigMap/test-namespace/myconfigmap/key")
+	require.NoError(t, err)
+
+	// The values of Secrets are masked, but not the ones of ConfigMaps
+	require.Equal(t, "***** ***** ***** k8s-config-value", mask.Mask("k8s-string-s3cret k8s-map-s3cret k8s-document-s3cret k8s-config-value"))
+}
+
+func Test_HealthCheck(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, nil, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+	require.NoError(t, api.HealthCheck(context.Background(), p))
+
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContext": "does-not-exist"}})
+	require.NoError(t, err)
+	require.EqualError(t, p.HealthCheck(context.Background()), `Unable to check the health of the Kubernetes API server: Unable to build config from vals configuration: context "does-not-exist" does not exist`)
+
+	// An API server rejecting the token
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, unauthorized.URL)}})
+	require.NoError(t, err)
+	err = p.HealthCheck(context.Background())
+	require.ErrorContains(t, err, "Unable to get the version of the Kubernetes API server: the server has asked for the client to provide credentials")
+	require.ErrorIs(t, err, api.ErrPermission)
+}
+
+func Test_GetString_KubeConfigContent(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+	kubeconfig, err := os.ReadFile(kubeConfigPath)
+	require.NoError(t, err)
+
+	// No kubeconfig file is needed
+	t.Setenv("KUBECONFIG", "/tmp/does-not-exist")
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigContent": string(kubeconfig)}})
+	require.NoError(t, err)
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+
+	// The kubeContext URI parameter still selects the context
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigContent": string(kubeconfig), "kubeContext": "does-not-exist"}})
+	require.NoError(t, err)
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorContains(t, err, "Unable to build config from the kubeconfig: invalid configuration: [context was not found for specified context: does-not-exist")
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigContent": string(kubeconfig), "kubeConfigPath": kubeConfigPath}})
+	require.EqualError(t, err, "kubeConfigPath and kubeConfigContent URI parameters are mutually exclusive.")
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigContent": string(kubeconfig), "inCluster": ""}})
+	require.EqualError(t, err, "inCluster and kubeConfigContent URI parameters are mutually exclusive.")
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigContent": "not a kubeconfig"}})
+	require.ErrorContains(t, err, "Unable to parse the kubeConfigContent URI parameter: ")
+}
+
+func Test_GetString_KubeConfigFromSecret(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+
+	// The tenant cluster holding the secret to look up
+	tenant := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromTenant")},
+		},
+	}, nil)
+	tenantKubeConfig, err := os.ReadFile(writeKubeConfig(t, tenant.URL))
+	require.NoError(t, err)
+
+	// The management cluster holding the kubeconfig of the tenant cluster
+	management := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromManagement")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "tenants", Name: "tenant-kubeconfig"},
+			Data: map[string][]byte{
+				"config":    tenantKubeConfig,
+				"malformed": []byte("not a kubeconfig"),
+			},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, management.URL)
+
+	tests := []struct {
+		kubeConfigFromSecret string
+		want                 string
+		wantErr              string
+	}{
+		{kubeConfigFromSecret: "tenants/tenant-kubeconfig/config", want: "fromTenant"},
+		{
+			kubeConfigFromSecret: "tenants/missing/config",
+			wantErr:              "Unable to get Secret test-namespace/mysecret: Unable to get the kubeconfig from Secret tenants/missing: ",
+		},
+		{
+			kubeConfigFromSecret: "tenants/tenant-kubeconfig/missing",
+			wantErr:              "Unable to get Secret test-namespace/mysecret: Key missing does not exist in Secret tenants/tenant-kubeconfig holding the kubeconfig",
+		},
+		{
+			kubeConfigFromSecret: "tenants/tenant-kubeconfig/malformed",
+			wantErr:              "Unable to get Secret test-namespace/mysecret: Unable to use the kubeconfig in key malformed of Secret tenants/tenant-kubeconfig: Unable to parse the kubeconfig: ",
+		},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(tc.kubeConfigFromSecret, func(t *testing.T) {
+			p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeConfigFromSecret": tc.kubeConfigFromSecret}})
+			require.NoError(t, err)
+
+			got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeConfigFromSecret": "tenants/tenant-kubeconfig"}})
+	require.EqualError(t, err, "Invalid kubeConfigFromSecret tenants/tenant-kubeconfig. It must be in the format namespace/secret/key.")
+}
+
+func Test_GetString_KubeConfigFromSecretRetries(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+
+	tenant := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("fromTenant")},
+		},
+	}, nil)
+	tenantKubeConfig, err := os.ReadFile(writeKubeConfig(t, tenant.URL))
+	require.NoError(t, err)
+
+	management := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "tenants", Name: "tenant-kubeconfig"},
+			Data:       map[string][]byte{"config": tenantKubeConfig},
+		},
+	}, nil)
+
+	tests := []struct {
+		retries      string
+		failures     int
+		wantErrs     int
+		wantRequests int
+	}{
+		// The kubeconfig Secret is fetched again after transient errors
+		{retries: "2", failures: 2, wantErrs: 0, wantRequests: 3},
+		// A lookup failing on transient errors does not fail the next one
+		{retries: "0", failures: 2, wantErrs: 2, wantRequests: 3},
+	}
+	for i := range tests {
+		tc := tests[i]
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if requests <= tc.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				management.Config.Handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, server.URL), "kubeConfigFromSecret": "tenants/tenant-kubeconfig/config", "retries": tc.retries}})
+			require.NoError(t, err)
+
+			for j := 0; j < tc.wantErrs; j++ {
+				_, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+				require.ErrorIs(t, err, api.ErrTransient)
+				require.ErrorContains(t, err, "Unable to get Secret test-namespace/mysecret: Unable to get the kubeconfig from Secret tenants/tenant-kubeconfig: ")
+			}
+
+			got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+			require.NoError(t, err)
+			require.Equal(t, "fromTenant", got)
+			require.Equal(t, tc.wantRequests, requests)
+		})
+	}
+}
+
+func Test_GetString_Watch(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	fake := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret", ResourceVersion: "1"},
+			Data:       map[string][]byte{"key": []byte("v1")},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "othersecret", ResourceVersion: "1"},
+			Data:       map[string][]byte{"key": []byte("other")},
+		},
+	}, nil)
+
+	// Rotate the secret via the watch on it
+	rotated := make(chan struct{})
+	var gets int
+	var m sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "true" {
+			if !strings.HasSuffix(r.URL.Path, "/secrets") {
+				m.Lock()
+				gets++
+				m.Unlock()
+			}
+			fake.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if strings.Contains(r.URL.Query().Get("fieldSelector"), "mysecret") {
+			secret := corev1.Secret{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+				ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret", ResourceVersion: "2"},
+				Data:       map[string][]byte{"key": []byte("v2")},
+			}
+			<-rotated
+			raw, err := json.Marshal(secret)
+			require.NoError(t, err)
+			_ = json.NewEncoder(w).Encode(metav1.WatchEvent{Type: "MODIFIED", Object: k8sruntime.RawExtension{Raw: raw}})
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, server.URL), "watch": "true", "timeout": "5s"}})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, p.Close()) }()
+
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "v1", got)
+
+	got, err = p.GetString("v1/Secret/test-namespace/othersecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "other", got)
+
+	close(rotated)
+	require.Eventually(t, func() bool {
+		got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+		return err == nil && got == "v2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The values are served from the watches, not fetched one by one
+	m.Lock()
+	require.Zero(t, gets)
+	m.Unlock()
+
+	_, err = p.GetString("v1/Secret/test-namespace/non-existent-secret/key")
+	require.ErrorIs(t, err, api.ErrNotFound)
+
+	p2, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, server.URL), "watch": "true", "fallback_value": "default"}})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, p2.Close()) }()
+
+	got, err = p2.GetString("v1/Secret/test-namespace/non-existent-secret/key")
+	require.NoError(t, err)
+	require.Equal(t, "default", got)
+}
+
+func Test_GetString_WatchForbidden(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+
+	// Deny listing the secrets to watch
+	var lists int
+	var m sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		lists++
+		m.Unlock()
+		status := apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", fmt.Errorf("cannot list secrets")).ErrStatus
+		status.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Status"}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(status)
+	}))
+	defer server.Close()
+
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeKubeConfig(t, server.URL), "watch": "true", "timeout": "30s"}})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, p.Close()) }()
+
+	// The lookup fails with the cause before the timeout
+	start := time.Now()
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorIs(t, err, api.ErrPermission)
+	require.Contains(t, err.Error(), "Unable to start the watch on Secret test-namespace/mysecret")
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	// The failed watch is not reused
+	p.watchers.m.Lock()
+	require.Empty(t, p.watchers.byKey)
+	p.watchers.m.Unlock()
+
+	m.Lock()
+	before := lists
+	m.Unlock()
+
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorIs(t, err, api.ErrPermission)
+
+	m.Lock()
+	require.Greater(t, lists, before)
+	m.Unlock()
+}
+
+func Test_GetString_KubeContexts(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	secrets := []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}
+	primary := newFakeAPIServer(t, nil, nil)
+	secondary := newFakeAPIServer(t, secrets, nil)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	writeFailoverKubeConfig := func(east string, west string) string {
+		kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: east
+  cluster:
+    server: %s
+- name: west
+  cluster:
+    server: %s
+contexts:
+- name: prod-east
+  context:
+    cluster: east
+    user: test-user
+- name: prod-west
+  context:
+    cluster: west
+    user: test-user
+current-context: prod-east
+users:
+- name: test-user
+  user:
+    token: test-token
+`, east, west)
+		path := filepath.Join(t.TempDir(), "config")
+		require.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0o600))
+		return path
+	}
+
+	// The connection to the primary is refused, so the secondary is tried
+	kubeConfigPath := writeFailoverKubeConfig(down.URL, secondary.URL)
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "prod-east, prod-west", "retries": "0"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"prod-east", "prod-west"}, p.KubeContexts)
+	got, err := p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+
+	got2, err := p.GetStringMap("v1/Secret/test-namespace/mysecret")
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"key": "p4ssw0rd"}, got2)
+
+	require.NoError(t, p.HealthCheck(context.Background()))
+
+	// A missing secret in the reachable primary is not masked by the secondary
+	kubeConfigPath = writeFailoverKubeConfig(primary.URL, secondary.URL)
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "prod-east,prod-west", "retries": "0"}})
+	require.NoError(t, err)
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorIs(t, err, api.ErrNotFound)
+
+	// Neither does a key of the primary that cannot be parsed as a document
+	invalid := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("db: [")},
+		},
+	}, nil)
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": writeFailoverKubeConfig(invalid.URL, secondary.URL), "kubeContexts": "prod-east,prod-west", "retries": "0"}})
+	require.NoError(t, err)
+	_, err = p.GetStringMap("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorContains(t, err, "Unable to parse key key of Secret test-namespace/mysecret as YAML or JSON")
+	require.NotContains(t, err.Error(), "kubeContexts")
+
+	// A context missing from the kubeconfig advances to the next one too
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "does-not-exist,prod-west", "retries": "0"}})
+	require.NoError(t, err)
+	got, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, "p4ssw0rd", got)
+
+	// The errors are aggregated when all the contexts fail
+	kubeConfigPath = writeFailoverKubeConfig(down.URL, down.URL)
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "prod-east,prod-west", "retries": "0"}})
+	require.NoError(t, err)
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.ErrorContains(t, err, "Unable to get v1/Secret/test-namespace/mysecret/key with any of the kubeContexts prod-east, prod-west: kubeContext prod-east: ")
+	require.ErrorContains(t, err, "\nkubeContext prod-west: ")
+	require.ErrorIs(t, err, api.ErrTransient)
+	require.Error(t, p.HealthCheck(context.Background()))
+
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key/extra")
+	require.ErrorContains(t, err, "Invalid path v1/Secret/test-namespace/mysecret/key/extra.")
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "prod-east,prod-west", "kubeContext": "prod-east"}})
+	require.EqualError(t, err, "kubeContext and kubeContexts URI parameters are mutually exclusive.")
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "prod-east,"}})
+	require.EqualError(t, err, "Invalid kubeContexts prod-east,. It must be a comma-separated list of kube contexts like prod-east,prod-west.")
+
+	_, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeContexts": "prod-east", "inCluster": ""}})
+	require.EqualError(t, err, "inCluster and kubeContexts URI parameters are mutually exclusive.")
+}
+
+// metricsReporter records the lookups reported to it
+type metricsReporter struct {
+	lookups []string
+	m       sync.Mutex
+}
+
+func (r *metricsReporter) ObserveLookup(provider, path string, dur time.Duration, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.lookups = append(r.lookups, fmt.Sprintf("%s %s %v", provider, path, err != nil))
+}
+
+func Test_GetString_Metrics(t *testing.T) {
+	logger := log.New(log.Config{Output: os.Stderr})
+	server := newFakeAPIServer(t, []corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-namespace", Name: "mysecret"},
+			Data:       map[string][]byte{"key": []byte("p4ssw0rd")},
+		},
+	}, nil)
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	m := &metricsReporter{}
+	p, err := New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath}})
+	require.NoError(t, err)
+	p.SetMetricsReporter(m)
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	_, err = p.GetStringMap("v1/Secret/test-namespace/missing")
+	require.ErrorIs(t, err, api.ErrNotFound)
+	require.Equal(t, []string{
+		"k8s v1/Secret/test-namespace/mysecret false",
+		"k8s v1/Secret/test-namespace/missing true",
+	}, m.lookups)
+
+	// The reporter is passed on to the providers of the kubeContexts. No object is fetched with the context missing from the kubeconfig.
+	m = &metricsReporter{}
+	p, err = New(logger, config.MapConfig{M: map[string]interface{}{"kubeConfigPath": kubeConfigPath, "kubeContexts": "does-not-exist,test-context"}})
+	require.NoError(t, err)
+	p.SetMetricsReporter(m)
+	_, err = p.GetString("v1/Secret/test-namespace/mysecret/key")
+	require.NoError(t, err)
+	require.Equal(t, []string{"k8s v1/Secret/test-namespace/mysecret false"}, m.lookups)
+}
@@ -0,0 +1,248 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/helmfile/vals/pkg/api"
+)
+
+// A watch on a single object, or the objects matching the label selector, keeping the latest observed state in memory
+type watcher struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+	// The last error listing or watching the objects, reported while waiting for the watch to sync
+	err error
+	m   sync.Mutex
+}
+
+// The watches of the provider, keyed by kind, namespace and name
+type watchers struct {
+	byKey map[string]*watcher
+	m     sync.Mutex
+}
+
+// Return the latest observed data of the object, starting a watch on it on first use.
+// The first call waits for the watch to sync, giving up once the configured timeout has elapsed.
+func (p *provider) getWatchedObject(clientset kubernetes.Interface, kind string, namespace string, name string) (map[string]string, error) {
+	w, err := p.watchers.get(clientset, kind, namespace, name, p.LabelSelector)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	if err := w.waitForSync(ctx); err != nil {
+		// The next lookup starts a new watch rather than waiting on the failed one
+		p.watchers.evict(kind, namespace, name, w)
+		if ctx.Err() != nil {
+			timeout := fmt.Errorf("Timed out after %s waiting for the watch on %s %s/%s to sync", p.Timeout, kind, namespace, name)
+			if !errors.Is(err, ctx.Err()) {
+				timeout = fmt.Errorf("%s: %w", timeout, err)
+			}
+			return nil, api.WithKind(timeout, api.ErrTransient)
+		}
+		return nil, fmt.Errorf("Unable to start the watch on %s %s/%s: %w", kind, namespace, name, classifyError(err))
+	}
+
+	objects := w.informer.GetStore().List()
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].(metav1.Object).GetName() < objects[j].(metav1.Object).GetName()
+	})
+
+	if name != nameSelectedByLabels && len(objects) == 0 {
+		err := apierrors.NewNotFound(schema.GroupResource{Resource: resourceOf(kind)}, name)
+		return nil, fmt.Errorf("Unable to get %s %s/%s: %w", kind, namespace, name, classifyError(err))
+	}
+
+	names := make([]string, 0, len(objects))
+	for _, object := range objects {
+		names = append(names, object.(metav1.Object).GetName())
+	}
+	i, err := selectOne(kind, namespace, p.LabelSelector, names)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get %s %s/%s: %w", kind, namespace, name, err)
+	}
+
+	switch object := objects[i].(type) {
+	case *corev1.Secret:
+		return convertByteMapToStringMap(object.Data), nil
+	case *corev1.ConfigMap:
+		return convertConfigMapDataToStringMap(object.Data, object.BinaryData), nil
+	default:
+		return nil, fmt.Errorf("Unexpected object of type %T in the watch on %s %s/%s", object, kind, namespace, name)
+	}
+}
+
+// Wait for the watch to sync, failing early on a permanent error listing the objects like Forbidden.
+// Transient errors are retried by the informer until ctx is done, and the last one is returned then.
+func (w *watcher) waitForSync(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if w.informer.HasSynced() {
+			return nil
+		}
+
+		w.m.Lock()
+		err := w.err
+		w.m.Unlock()
+
+		if err != nil && !isTransientError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// Return the key of the watch on the object
+func watchKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// Return the watch on the object, starting it if it is not running yet
+func (ws *watchers) get(clientset kubernetes.Interface, kind string, namespace string, name string, labelSelector string) (*watcher, error) {
+	if err := validateName(kind, name, labelSelector); err != nil {
+		return nil, err
+	}
+
+	key := watchKey(kind, namespace, name)
+
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	if w, ok := ws.byKey[key]; ok {
+		return w, nil
+	}
+
+	w, err := newWatcher(clientset, kind, namespace, name, labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	if ws.byKey == nil {
+		ws.byKey = map[string]*watcher{}
+	}
+	ws.byKey[key] = w
+
+	return w, nil
+}
+
+// Stop the watch on the object and forget it, unless it has already been replaced
+func (ws *watchers) evict(kind string, namespace string, name string, w *watcher) {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	key := watchKey(kind, namespace, name)
+	if ws.byKey[key] == w {
+		delete(ws.byKey, key)
+		close(w.stop)
+	}
+}
+
+// Stop all the watches
+func (ws *watchers) stop() {
+	ws.m.Lock()
+	defer ws.m.Unlock()
+
+	for _, w := range ws.byKey {
+		close(w.stop)
+	}
+	ws.byKey = nil
+}
+
+func newWatcher(clientset kubernetes.Interface, kind string, namespace string, name string, labelSelector string) (*watcher, error) {
+	// Watch only the referenced object, or the objects matching the label selector
+	tweak := func(options *metav1.ListOptions) {
+		if name == nameSelectedByLabels {
+			options.LabelSelector = labelSelector
+		} else {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}
+	}
+
+	var lw *cache.ListWatch
+	var object runtime.Object
+
+	switch kind {
+	case "Secret":
+		secrets := clientset.CoreV1().Secrets(namespace)
+		lw = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return secrets.List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return secrets.Watch(context.Background(), options)
+			},
+		}
+		object = &corev1.Secret{}
+	case "ConfigMap":
+		configmaps := clientset.CoreV1().ConfigMaps(namespace)
+		lw = &cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				tweak(&options)
+				return configmaps.List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				tweak(&options)
+				return configmaps.Watch(context.Background(), options)
+			},
+		}
+		object = &corev1.ConfigMap{}
+	default:
+		return nil, fmt.Errorf("The specified kind is not valid. Valid kinds: Secret, ConfigMap")
+	}
+
+	w := &watcher{
+		informer: cache.NewSharedIndexInformer(lw, object, 0, cache.Indexers{}),
+		stop:     make(chan struct{}),
+	}
+	// Keep the error for waitForSync instead of only logging it, so that a lookup fails with the actual cause like Forbidden
+	if err := w.informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		w.m.Lock()
+		defer w.m.Unlock()
+		w.err = err
+	}); err != nil {
+		return nil, err
+	}
+	go w.informer.Run(w.stop)
+
+	return w, nil
+}
+
+// Return the name of the API resource of the kind
+func resourceOf(kind string) string {
+	switch kind {
+	case "Secret":
+		return "secrets"
+	case "ConfigMap":
+		return "configmaps"
+	default:
+		return kind
+	}
+}
@@ -0,0 +1,52 @@
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// DefaultRetries is the number of retries used when a provider has no retries param set
+const DefaultRetries = 3
+
+const (
+	baseDelay = 200 * time.Millisecond
+	maxDelay  = 5 * time.Second
+)
+
+// sleep is replaced in tests to avoid waiting for real
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Do calls fn until it succeeds, fails with an error that is not retryable, or has been retried the given number of times.
+// Retries are delayed with exponential backoff and full jitter, and stop as soon as ctx is done.
+// The error from the last attempt is returned.
+func Do(ctx context.Context, retries int, retryable func(error) bool, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+		if sleepErr := sleep(ctx, backoff(attempt)); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// backoff returns a random delay between zero and the exponentially growing cap for the attempt
+func backoff(attempt int) time.Duration {
+	d := maxDelay
+	if attempt < 10 {
+		d = min(baseDelay<<attempt, maxDelay)
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
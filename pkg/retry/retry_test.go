@@ -0,0 +1,84 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestDo(t *testing.T) {
+	var delays []time.Duration
+	origSleep := sleep
+	sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	t.Cleanup(func() { sleep = origSleep })
+
+	tests := []struct {
+		name      string
+		errs      []error
+		retries   int
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds at once", nil, 3, 1, nil},
+		{"succeeds after transient errors", []error{errTransient, errTransient}, 3, 3, nil},
+		{"gives up after retries", []error{errTransient, errTransient, errTransient, errTransient}, 3, 4, errTransient},
+		{"does not retry permanent errors", []error{errPermanent}, 3, 1, errPermanent},
+		{"does not retry when retries is zero", []error{errTransient}, 0, 1, errTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays = nil
+			calls := 0
+			err := Do(context.Background(), tt.retries, isTransient, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			require.ErrorIs(t, err, tt.wantErr)
+			require.Equal(t, tt.wantCalls, calls)
+			require.Len(t, delays, tt.wantCalls-1)
+		})
+	}
+}
+
+func TestDo_ContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := Do(ctx, 3, isTransient, func() error {
+		calls++
+		return errTransient
+	})
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, calls)
+}
+
+func Test_backoff(t *testing.T) {
+	for attempt := 0; attempt < 100; attempt++ {
+		d := backoff(attempt)
+		require.GreaterOrEqual(t, d, time.Duration(0))
+		require.LessOrEqual(t, d, maxDelay)
+		if attempt == 0 {
+			require.LessOrEqual(t, d, baseDelay)
+		}
+	}
+}
@@ -34,7 +34,7 @@ func New(l *log.Logger, provider api.StaticConfig) (api.LazyLoadedStringMapProvi
 	case "sops":
 		return sops.New(l, provider), nil
 	case "gcpsecrets":
-		return gcpsecrets.New(provider), nil
+		return gcpsecrets.New(l, provider)
 	case "azurekeyvault":
 		return azurekeyvault.New(provider), nil
 	case "awskms":
@@ -46,7 +46,7 @@ func New(l *log.Logger, provider api.StaticConfig) (api.LazyLoadedStringProvider
 	case "sops":
 		return sops.New(l, provider), nil
 	case "gcpsecrets":
-		return gcpsecrets.New(provider), nil
+		return gcpsecrets.New(l, provider)
 	case "tfstate":
 		return tfstate.New(provider, ""), nil
 	case "tfstategs":
@@ -10,16 +10,20 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
 	"gopkg.in/yaml.v3"
 
 	"github.com/helmfile/vals/pkg/api"
+	"github.com/helmfile/vals/pkg/cachedprovider"
 	"github.com/helmfile/vals/pkg/config"
 	"github.com/helmfile/vals/pkg/expansion"
 	"github.com/helmfile/vals/pkg/log"
+	"github.com/helmfile/vals/pkg/mask"
 	"github.com/helmfile/vals/pkg/providers/awskms"
 	"github.com/helmfile/vals/pkg/providers/awssecrets"
 	"github.com/helmfile/vals/pkg/providers/azurekeyvault"
@@ -27,6 +31,7 @@ import (
 	"github.com/helmfile/vals/pkg/providers/conjur"
 	"github.com/helmfile/vals/pkg/providers/doppler"
 	"github.com/helmfile/vals/pkg/providers/echo"
+	envprovider "github.com/helmfile/vals/pkg/providers/env"
 	"github.com/helmfile/vals/pkg/providers/envsubst"
 	"github.com/helmfile/vals/pkg/providers/file"
 	"github.com/helmfile/vals/pkg/providers/gcpsecrets"
@@ -92,6 +97,7 @@ const (
 	ProviderTFStateRemote      = "tfstateremote"
 	ProviderAzureKeyVault      = "azurekeyvault"
 	ProviderEnvSubst           = "envsubst"
+	ProviderEnv                = "env"
 	ProviderKeychain           = "keychain"
 	ProviderOnePassword        = "op"
 	ProviderOnePasswordConnect = "onepasswordconnect"
@@ -118,6 +124,7 @@ type Runtime struct {
 	providers map[string]api.Provider
 	docCache  *lru.Cache // secret documents are cached to improve performance
 	strCache  *lru.Cache // secrets are cached to improve performance
+	cache     *cachedprovider.Cache
 	logger    *log.Logger
 	Options   Options
 	m         sync.Mutex
@@ -131,6 +138,7 @@ func New(opts Options) (*Runtime, error) {
 	}
 	r := &Runtime{
 		providers: map[string]api.Provider{},
+		cache:     cachedprovider.NewCacheWithSize(cacheSize),
 		Options:   opts,
 		logger: log.New(log.Config{
 			Output: opts.LogOutput,
@@ -148,6 +156,128 @@ func New(opts Options) (*Runtime, error) {
 	return r, nil
 }
 
+// cacheTTL returns how long the values of the reference are cached, as specified by its cache_ttl param or Options.CacheTTL.
+// A zero TTL caches the values for the lifetime of the Runtime.
+func (r *Runtime) cacheTTL(uri *url.URL) (time.Duration, error) {
+	v := uri.Query().Get("cache_ttl")
+	if v == "" {
+		return r.Options.CacheTTL, nil
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl < 0 {
+		return 0, fmt.Errorf("invalid cache_ttl %q: cache_ttl must be a non-negative duration like 10m", v)
+	}
+	return ttl, nil
+}
+
+// secretProviders are the providers whose values are secrets, which the Runtime registers in mask.Default.
+// The values of the other providers, like the contents of a file or an envvar, are left unmasked.
+// The gcpsecrets and k8s providers register their values themselves, leaving out dry-run placeholders,
+// fallback values and the values of ConfigMaps, so they are not listed here.
+var secretProviders = map[string]bool{
+	ProviderVault:              true,
+	ProviderGitLab:             true,
+	ProviderSSM:                true,
+	ProviderKms:                true,
+	ProviderSecretsManager:     true,
+	ProviderSOPS:               true,
+	ProviderAzureKeyVault:      true,
+	ProviderKeychain:           true,
+	ProviderOnePassword:        true,
+	ProviderOnePasswordConnect: true,
+	ProviderDoppler:            true,
+	ProviderGKMS:               true,
+	ProviderConjur:             true,
+	ProviderHCPVaultSecrets:    true,
+	ProviderBitwarden:          true,
+}
+
+// secretValue returns whether the Runtime registers the values of the provider in mask.Default.
+// Placeholders returned in dry runs are not secrets.
+func secretValue(scheme string, p api.Provider) bool {
+	return secretProviders[strings.Split(scheme, "://")[0]] && !api.DryRun(p)
+}
+
+// strictFragments are the providers whose fragments must resolve to a scalar,
+// so that a map or list at the fragment is an error regardless of Options.FailOnMissingKeyInMap,
+// and so is a missing key unless the optional param is set.
+var strictFragments = map[string]bool{
+	ProviderGCPSecretManager: true,
+	ProviderK8s:              true,
+}
+
+// fragmentValue returns the value at the fragment like db/port within obj, formatting numbers and booleans as strings,
+// and whether there is such a value.
+// With failOnMissing a missing key, and with failOnNonScalar a map or list at the fragment, is an error.
+// Otherwise, it is reported as no value.
+func fragmentValue(obj map[string]interface{}, frag string, failOnMissing, failOnNonScalar bool) (string, bool, error) {
+	keys := strings.Split(frag, "/")
+	for i, k := range keys {
+		v, ok := obj[k]
+		if !ok {
+			break
+		}
+
+		if i == len(keys)-1 {
+			switch t := v.(type) {
+			case map[string]interface{}, map[interface{}]interface{}, []interface{}, nil:
+				if failOnNonScalar {
+					return "", false, fmt.Errorf("value for key %s is not a scalar: got %v(%T)", frag, t, t)
+				}
+				return "", false, nil
+			default:
+				return fmt.Sprint(t), true, nil
+			}
+		}
+
+		switch t := v.(type) {
+		case map[string]interface{}:
+			obj = t
+		case map[interface{}]interface{}:
+			obj = map[string]interface{}{}
+			for k, v := range t {
+				obj[fmt.Sprintf("%v", k)] = v
+			}
+		default:
+			return "", false, fmt.Errorf("unexpected type of value for key at %d=%s in %v: expected map[string]interface{}, got %v(%T)", i, k, keys, t, t)
+		}
+	}
+
+	if failOnMissing {
+		return "", false, fmt.Errorf("no value found for key %s", frag)
+	}
+
+	return "", false, nil
+}
+
+// watched returns whether the reference is served from a watch on the backend, which keeps its values up to date by itself.
+func watched(uri *url.URL) bool {
+	if strings.Split(uri.Scheme, "://")[0] != ProviderK8s {
+		return false
+	}
+	watch, _ := strconv.ParseBool(uri.Query().Get("watch"))
+	return watch
+}
+
+// Close closes the providers holding resources like connections or watches, and forgets all the providers.
+// The Runtime can still be used afterwards, creating the providers again as needed.
+func (r *Runtime) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	var errs []error
+	for _, p := range r.providers {
+		if c, ok := p.(interface{ Close() error }); ok {
+			if err := c.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	r.providers = map[string]api.Provider{}
+
+	return errors.Join(errs...)
+}
+
 // nolint
 func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 	var err error
@@ -219,7 +349,16 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 			p := file.New(conf)
 			return p, nil
 		case ProviderGCPSecretManager:
-			p := gcpsecrets.New(conf)
+			// The pins of latest last as long as the cached values, so that a rotated secret is picked up once they expire
+			if _, ok := m["cache_ttl"]; !ok && r.Options.CacheTTL > 0 {
+				m["cache_ttl"] = r.Options.CacheTTL.String()
+			}
+			p, err := gcpsecrets.New(r.logger, conf)
+			if err != nil {
+				return nil, err
+			}
+			p.SetMetricsReporter(r.Options.MetricsReporter)
+			p.SetStore(r.Options.Store)
 			return p, nil
 		case ProviderGoogleSheets:
 			return googlesheets.New(conf), nil
@@ -250,6 +389,9 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 		case ProviderEnvSubst:
 			p := envsubst.New(conf)
 			return p, nil
+		case ProviderEnv:
+			p := envprovider.New(conf)
+			return p, nil
 		case ProviderOnePassword:
 			p := onepassword.New(conf)
 			return p, nil
@@ -266,7 +408,12 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 			p := gkms.New(r.logger, conf)
 			return p, nil
 		case ProviderK8s:
-			return k8s.New(r.logger, conf)
+			p, err := k8s.New(r.logger, conf)
+			if err != nil {
+				return nil, err
+			}
+			p.SetMetricsReporter(r.Options.MetricsReporter)
+			return p, nil
 		case ProviderConjur:
 			p := conjur.New(r.logger, conf)
 			return p, nil
@@ -283,7 +430,7 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 		return nil, fmt.Errorf("no provider registered for scheme %q", scheme)
 	}
 
-	updateProviders := func(uri *url.URL, hash string) (api.Provider, error) {
+	updateProviders := func(uri *url.URL, hash string, ttl time.Duration, cached bool) (api.Provider, error) {
 		r.m.Lock()
 		defer r.m.Unlock()
 		p, ok := r.providers[hash]
@@ -297,6 +444,10 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 				return nil, err
 			}
 
+			if cached {
+				p = cachedprovider.NewWithTTL(r.cache, hash, ttl, p)
+			}
+
 			r.providers[hash] = p
 		}
 		return p, nil
@@ -311,7 +462,28 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 		Only:   only,
 		Target: expansion.DefaultRefRegexp,
 		Lookup: func(key string) (string, error) {
-			if val, ok := r.docCache.Get(key); ok {
+			uri, err := url.Parse(key)
+			if err != nil {
+				return "", err
+			}
+
+			ttl, err := r.cacheTTL(uri)
+			if err != nil {
+				return "", err
+			}
+
+			// Values with a TTL are cached only by the provider, which knows when they expire.
+			// Watched values are not cached at all, so that each lookup sees the latest value from the watch,
+			// and neither are any values when caching is disabled via VALS_DISABLE_CACHE.
+			cacheGet := func(c *lru.Cache, key string) (interface{}, bool) { return c.Get(key) }
+			cacheAdd := func(c *lru.Cache, key string, value interface{}) { c.Add(key, value) }
+			isWatched := watched(uri)
+			if ttl > 0 || isWatched || cachedprovider.Disabled() {
+				cacheGet = func(*lru.Cache, string) (interface{}, bool) { return nil, false }
+				cacheAdd = func(*lru.Cache, string, interface{}) {}
+			}
+
+			if val, ok := cacheGet(r.docCache, key); ok {
 				valStr, ok := val.(string)
 				if !ok {
 					return "", fmt.Errorf("error reading string from cache: unsupported value type %T", val)
@@ -319,14 +491,9 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 				return valStr, nil
 			}
 
-			uri, err := url.Parse(key)
-			if err != nil {
-				return "", err
-			}
-
 			hash := uriToProviderHash(uri)
 
-			p, err := updateProviders(uri, hash)
+			p, err := updateProviders(uri, hash, ttl, !isWatched)
 
 			if err != nil {
 				return "", err
@@ -365,7 +532,7 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 			if len(frag) == 0 {
 				var str string
 				cacheKey := key
-				if cachedStr, ok := r.strCache.Get(cacheKey); ok {
+				if cachedStr, ok := cacheGet(r.strCache, cacheKey); ok {
 					str, ok = cachedStr.(string)
 					if !ok {
 						return "", fmt.Errorf("error reading str from cache: unsupported value type %T", cachedStr)
@@ -375,14 +542,21 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 					if err != nil {
 						return "", err
 					}
-					r.strCache.Add(cacheKey, str)
+					cacheAdd(r.strCache, cacheKey, str)
+				}
+
+				if secretValue(uri.Scheme, p) {
+					mask.Add(str)
 				}
 
 				return str, nil
+			} else if api.DryRun(p) {
+				// The keys within the value are unknown, so the field resolves to the placeholder for the whole value
+				return p.GetString(path)
 			} else {
 				mapRequestURI := key[:strings.LastIndex(key, uri.Fragment)-1]
 				var obj map[string]interface{}
-				if cachedMap, ok := r.docCache.Get(mapRequestURI); ok {
+				if cachedMap, ok := cacheGet(r.docCache, mapRequestURI); ok {
 					obj, ok = cachedMap.(map[string]interface{})
 					if !ok {
 						return "", fmt.Errorf("error reading map from cache: unsupported value type %T", cachedMap)
@@ -405,34 +579,23 @@ func (r *Runtime) prepare() (*expansion.ExpandRegexMatch, error) {
 					if err != nil {
 						return "", err
 					}
-					r.docCache.Add(mapRequestURI, obj)
+					cacheAdd(r.docCache, mapRequestURI, obj)
 				}
 
-				keys := strings.Split(frag, "/")
-				for i, k := range keys {
-					newobj := map[string]interface{}{}
-					switch t := obj[k].(type) {
-					case string:
-						if i != len(keys)-1 {
-							return "", fmt.Errorf("unexpected type of value for key at %d=%s in %v: expected map[string]interface{}, got %v(%T)", i, k, keys, t, t)
-						}
-						r.docCache.Add(key, t)
-						return t, nil
-					case map[string]interface{}:
-						newobj = t
-					case map[interface{}]interface{}:
-						for k, v := range t {
-							newobj[fmt.Sprintf("%v", k)] = v
-						}
-					}
-					obj = newobj
+				// An optional secret that is missing comes as an empty map, whose keys are missing too
+				strict := strictFragments[strings.Split(uri.Scheme, "://")[0]]
+				optional, _ := strconv.ParseBool(uri.Query().Get("optional"))
+				failOnMissing := r.Options.FailOnMissingKeyInMap || (strict && !optional)
+				failOnNonScalar := r.Options.FailOnMissingKeyInMap || strict
+				str, found, err := fragmentValue(obj, frag, failOnMissing, failOnNonScalar)
+				if err != nil || !found {
+					return "", err
 				}
-
-				if r.Options.FailOnMissingKeyInMap {
-					return "", fmt.Errorf("no value found for key %s", frag)
+				cacheAdd(r.docCache, key, str)
+				if secretValue(uri.Scheme, p) {
+					mask.Add(str)
 				}
-
-				return "", nil
+				return str, nil
 			}
 		},
 	}
@@ -511,10 +674,17 @@ func IgnorePrefix(p string) Option {
 }
 
 type Options struct {
-	LogOutput             io.Writer
-	CacheSize             int
+	LogOutput io.Writer
+	CacheSize int
+	// CacheTTL is how long values are cached before being fetched from the backend again.
+	// Zero caches them for the lifetime of the Runtime. The cache_ttl param of a reference takes precedence.
+	CacheTTL              time.Duration
 	ExcludeSecret         bool
 	FailOnMissingKeyInMap bool
+	// MetricsReporter observes the calls that the gcpsecrets and k8s providers make to their backends. Nil observes nothing.
+	MetricsReporter api.MetricsReporter
+	// Store caches the values fetched by the gcpsecrets provider across processes. Nil caches nothing beyond the Runtime.
+	Store api.Store
 }
 
 var unsafeCharRegexp = regexp.MustCompile(`[^\w@%+=:,./-]`)
@@ -830,12 +1000,16 @@ func Load(conf api.StaticConfig, opt ...Option) (map[string]interface{}, error)
 		return nil, fmt.Errorf("path, prefix, paths, or keys must be provided")
 	}
 
+	// The provider config is shared by all the keys within this call, so the provider name suffices as the cache ID
+	cache := cachedprovider.NewCache()
+
 	switch tpe {
 	case TypeString:
 		p, err := stringprovider.New(l, provider)
 		if err != nil {
 			return nil, err
 		}
+		p = cachedprovider.NewString(cache, name, p)
 		res, err := expansion.ModifyStringValues(keymap, func(path string) (interface{}, error) {
 			if ctx.ignorePrefix != "" && strings.HasPrefix(path, ctx.ignorePrefix) {
 				return path, nil
@@ -860,10 +1034,12 @@ func Load(conf api.StaticConfig, opt ...Option) (map[string]interface{}, error)
 		if err != nil {
 			return nil, err
 		}
+		p = cachedprovider.NewStringMap(cache, name, p)
 		pp, err := stringprovider.New(l, provider)
 		if err != nil {
 			return nil, err
 		}
+		pp = cachedprovider.NewString(cache, name, pp)
 		getMap := func(path string) (map[string]interface{}, error) {
 			if format == FormatYAML {
 				str, err := pp.GetString(path)
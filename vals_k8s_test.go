@@ -74,6 +74,16 @@ func TestValues_k8s(t *testing.T) {
 			want:    nil,
 			wantErr: "expand k8s://v1/Secret/test-namespace/non-existent-secret/key: Unable to get Secret test-namespace/non-existent-secret: Unable to get the Secret object from Kubernetes: secrets \"non-existent-secret\" not found",
 		},
+		// (secret) valid Secret is specified, key is selected via the fragment
+		{
+			template: map[string]interface{}{
+				"test_key": "ref+k8s://v1/Secret/test-namespace/mysecret#/key",
+			},
+			want: map[string]interface{}{
+				"test_key": "p4ssw0rd",
+			},
+			wantErr: "",
+		},
 		// (configmap) valid ConfigMap is specified, using current context
 		{
 			template: map[string]interface{}{
@@ -2,13 +2,21 @@ package vals
 
 import (
 	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/helmfile/vals/pkg/api"
+	"github.com/helmfile/vals/pkg/mask"
 )
 
 func TestExec(t *testing.T) {
@@ -149,3 +157,328 @@ func TestEvalNodesWithDictionaries(t *testing.T) {
 
 	require.Equal(t, expected, buf.String())
 }
+
+func TestRuntime_CacheTTL(t *testing.T) {
+	r, err := New(Options{})
+	require.NoError(t, err)
+
+	eval := func(ref string) string {
+		t.Helper()
+		got, err := r.Eval(map[string]interface{}{"v": ref})
+		require.NoError(t, err)
+		return got["v"].(string)
+	}
+
+	t.Setenv("VALS_TEST_CACHE_TTL", "v1")
+	require.Equal(t, "v1", eval("ref+env://VALS_TEST_CACHE_TTL"))
+	require.Equal(t, "v1", eval("ref+env://VALS_TEST_CACHE_TTL?cache_ttl=1ms"))
+
+	t.Setenv("VALS_TEST_CACHE_TTL", "v2")
+	time.Sleep(2 * time.Millisecond)
+
+	// Values without a TTL are cached for the lifetime of the runtime
+	require.Equal(t, "v1", eval("ref+env://VALS_TEST_CACHE_TTL"))
+	require.Equal(t, "v2", eval("ref+env://VALS_TEST_CACHE_TTL?cache_ttl=1ms"))
+
+	_, err = r.Eval(map[string]interface{}{"v": "ref+env://VALS_TEST_CACHE_TTL?cache_ttl=soon"})
+	require.ErrorContains(t, err, `invalid cache_ttl "soon": cache_ttl must be a non-negative duration like 10m`)
+}
+
+func TestRuntime_DisableCache(t *testing.T) {
+	t.Setenv("VALS_DISABLE_CACHE", "true")
+
+	r, err := New(Options{})
+	require.NoError(t, err)
+
+	eval := func(ref string) string {
+		t.Helper()
+		got, err := r.Eval(map[string]interface{}{"v": ref})
+		require.NoError(t, err)
+		return got["v"].(string)
+	}
+
+	t.Setenv("VALS_TEST_DISABLE_CACHE", "v1")
+	require.Equal(t, "v1", eval("ref+env://VALS_TEST_DISABLE_CACHE"))
+
+	// Every reference is looked up again
+	t.Setenv("VALS_TEST_DISABLE_CACHE", "v2")
+	require.Equal(t, "v2", eval("ref+env://VALS_TEST_DISABLE_CACHE"))
+}
+
+func TestRuntime_Watch(t *testing.T) {
+	secret := func(version, value string) string {
+		return fmt.Sprintf(`{"apiVersion":"v1","kind":"Secret","metadata":{"namespace":"test-namespace","name":"mysecret","resourceVersion":%q},"data":{"key":%q}}`, version, value)
+	}
+
+	// Rotate the secret from djE= (v1) to djI= (v2) via the watch on it
+	rotated := make(chan struct{})
+	watching := make(chan struct{}, 1)
+	stopped := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprintf(w, `{"apiVersion":"v1","kind":"SecretList","metadata":{"resourceVersion":"1"},"items":[%s]}`, secret("1", "djE="))
+			return
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case watching <- struct{}{}:
+		default:
+		}
+		select {
+		case <-rotated:
+			fmt.Fprintf(w, `{"type":"MODIFIED","object":%s}`, secret("2", "djI="))
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+		}
+		<-r.Context().Done()
+		select {
+		case stopped <- struct{}{}:
+		default:
+		}
+	}))
+	defer func() {
+		server.CloseClientConnections()
+		server.Close()
+	}()
+
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	r, err := New(Options{})
+	require.NoError(t, err)
+
+	ref := fmt.Sprintf("ref+k8s://v1/Secret/test-namespace/mysecret/key?watch=true&kubeConfigPath=%s", kubeConfigPath)
+	eval := func() (string, error) {
+		got, err := r.Eval(map[string]interface{}{"v": ref})
+		if err != nil {
+			return "", err
+		}
+		return got["v"].(string), nil
+	}
+
+	got, err := eval()
+	require.NoError(t, err)
+	require.Equal(t, "v1", got)
+
+	// Watched values are not cached by the runtime
+	<-watching
+	close(rotated)
+	require.Eventually(t, func() bool {
+		got, err := eval()
+		return err == nil && got == "v2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Closing the runtime stops the watch
+	require.NoError(t, r.Close())
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch was not stopped")
+	}
+}
+
+func TestRuntime_DryRun(t *testing.T) {
+	r, err := New(Options{})
+	require.NoError(t, err)
+
+	got, err := r.Eval(map[string]interface{}{
+		"field": "ref+gcpsecrets://myproject/mysecret?dry_run=true#/db/password",
+		"raw":   "ref+gcpsecrets://myproject/mycert?dry_run=true&raw=true&include_metadata=true#/value",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"field": "<gcpsecrets:myproject/mysecret@latest>",
+		"raw":   "<gcpsecrets:myproject/mycert@latest>",
+	}, got)
+
+	// Placeholders are not secrets
+	require.Equal(t, "<gcpsecrets:myproject/mysecret@latest>", mask.Mask("<gcpsecrets:myproject/mysecret@latest>"))
+}
+
+func TestRuntime_Mask(t *testing.T) {
+	r, err := New(Options{})
+	require.NoError(t, err)
+
+	// Values of plain providers like env are not secrets
+	t.Setenv("VALS_TEST_MASK", "pl4in-v4lue")
+	_, err = r.Eval(map[string]interface{}{"v": "ref+env://VALS_TEST_MASK"})
+	require.NoError(t, err)
+
+	require.Equal(t, "value=pl4in-v4lue", mask.Mask("value=pl4in-v4lue"))
+
+	// Values of k8s Secrets are registered by the provider, while fallback values are not secrets
+	mask.Reset()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"apiVersion":"v1","kind":"Secret","metadata":{"namespace":"test-namespace","name":"mysecret"},"data":{"key":"czNjcjN0LXY0bHVl"}}`)
+	}))
+	defer server.Close()
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	got, err := r.Eval(map[string]interface{}{
+		"secret":   fmt.Sprintf("ref+k8s://v1/Secret/test-namespace/mysecret/key?kubeConfigPath=%s", kubeConfigPath),
+		"fallback": fmt.Sprintf("ref+k8s://v1/Secret/test-namespace/mysecret/missing?kubeConfigPath=%s&fallback_value=f4llb4ck-v4lue", kubeConfigPath),
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"secret": "s3cr3t-v4lue", "fallback": "f4llb4ck-v4lue"}, got)
+
+	require.Equal(t, "secret=*****", mask.Mask("secret=s3cr3t-v4lue"))
+	require.Equal(t, "fallback=f4llb4ck-v4lue", mask.Mask("fallback=f4llb4ck-v4lue"))
+	require.Equal(t, []string{"s3cr3t-v4lue"}, mask.Default.Values())
+}
+
+// dryRunProvider is a provider in a dry run, returning placeholders
+type dryRunProvider struct {
+	api.Provider
+}
+
+func (dryRunProvider) DryRun() bool {
+	return true
+}
+
+func TestSecretValue(t *testing.T) {
+	tests := []struct {
+		scheme string
+		p      api.Provider
+		want   bool
+	}{
+		{"vault", nil, true},
+		{"vault", dryRunProvider{}, false},
+		// gcpsecrets and k8s register their values themselves
+		{"gcpsecrets", nil, false},
+		{"k8s", nil, false},
+		{"env", nil, false},
+		{"file", nil, false},
+		{"echo", nil, false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, secretValue(tt.scheme, tt.p), "%s (%T)", tt.scheme, tt.p)
+	}
+}
+
+func TestFragmentValue(t *testing.T) {
+	obj := map[string]interface{}{
+		"db": map[string]interface{}{
+			"host":  "localhost",
+			"port":  5432,
+			"ratio": 0.5,
+			"tls":   true,
+			"users": []interface{}{"alice", "bob"},
+			"opts":  map[interface{}]interface{}{"timeout": 30},
+			"empty": nil,
+		},
+	}
+
+	tests := []struct {
+		frag      string
+		strict    bool
+		want      string
+		wantFound bool
+		wantErr   string
+	}{
+		{frag: "db/host", want: "localhost", wantFound: true},
+		{frag: "db/port", want: "5432", wantFound: true},
+		{frag: "db/ratio", want: "0.5", wantFound: true},
+		{frag: "db/tls", want: "true", wantFound: true},
+		{frag: "db/opts/timeout", want: "30", wantFound: true},
+		{frag: "db/missing"},
+		{frag: "db/missing", strict: true, wantErr: "no value found for key db/missing"},
+		{frag: "db/users"},
+		{frag: "db/users", strict: true, wantErr: "value for key db/users is not a scalar: got [alice bob]([]interface {})"},
+		{frag: "db/opts", strict: true, wantErr: "value for key db/opts is not a scalar: got map[timeout:30](map[interface {}]interface {})"},
+		{frag: "db", strict: true, wantErr: "value for key db is not a scalar"},
+		{frag: "db/empty", strict: true, wantErr: "value for key db/empty is not a scalar: got <nil>(<nil>)"},
+		{frag: "db/host/name", wantErr: "unexpected type of value for key at 1=host"},
+		{frag: "db/port/name", wantErr: "unexpected type of value for key at 1=port"},
+	}
+	for _, tt := range tests {
+		got, found, err := fragmentValue(obj, tt.frag, tt.strict, tt.strict)
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, "%s (strict=%v)", tt.frag, tt.strict)
+			continue
+		}
+		require.NoError(t, err, "%s (strict=%v)", tt.frag, tt.strict)
+		require.Equal(t, tt.want, got, tt.frag)
+		require.Equal(t, tt.wantFound, found, tt.frag)
+	}
+
+	// The keys of an optional secret that is missing are missing too, while a map is still not a value
+	_, found, err := fragmentValue(obj, "db/missing", false, true)
+	require.NoError(t, err)
+	require.False(t, found)
+	_, _, err = fragmentValue(obj, "db/opts", false, true)
+	require.ErrorContains(t, err, "is not a scalar")
+}
+
+// writeKubeConfig writes a kubeconfig for the API server at the URL, returning its path
+func writeKubeConfig(t *testing.T, url string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test-cluster
+  cluster:
+    server: %s
+contexts:
+- name: test-context
+  context:
+    cluster: test-cluster
+    user: test-user
+current-context: test-context
+users:
+- name: test-user
+  user:
+    token: test-token
+`, url)), 0o600))
+
+	return path
+}
+
+func TestRuntime_K8sKeyDocument(t *testing.T) {
+	// config.yaml holds a YAML document with fields of various types
+	config := base64.StdEncoding.EncodeToString([]byte(`db:
+  host: db.example.com
+  port: 5432
+  tls: true
+  replicas:
+  - db-1.example.com
+`))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"apiVersion":"v1","kind":"Secret","metadata":{"namespace":"test-namespace","name":"mysecret"},"data":{"config.yaml":%q}}`, config)
+	}))
+	defer server.Close()
+
+	kubeConfigPath := writeKubeConfig(t, server.URL)
+
+	tests := []struct {
+		fragment string
+		params   string
+		want     string
+		wantErr  string
+	}{
+		{fragment: "db/host", want: "db.example.com"},
+		{fragment: "db/port", want: "5432"},
+		{fragment: "db/tls", want: "true"},
+		{fragment: "db/missing", params: "&optional=true", want: ""},
+		{fragment: "db/missing", wantErr: "no value found for key db/missing"},
+		{fragment: "db/replicas", wantErr: "value for key db/replicas is not a scalar"},
+		{fragment: "db", wantErr: "value for key db is not a scalar"},
+		{fragment: "db/port/number", wantErr: "unexpected type of value for key at 1=port"},
+	}
+	for _, tt := range tests {
+		r, err := New(Options{})
+		require.NoError(t, err)
+
+		ref := fmt.Sprintf("ref+k8s://v1/Secret/test-namespace/mysecret/config.yaml?kubeConfigPath=%s%s#/%s", kubeConfigPath, tt.params, tt.fragment)
+		got, err := r.Eval(map[string]interface{}{"v": ref})
+		if tt.wantErr != "" {
+			require.ErrorContains(t, err, tt.wantErr, ref)
+			continue
+		}
+		require.NoError(t, err, ref)
+		require.Equal(t, tt.want, got["v"], ref)
+	}
+}